@@ -1,4 +0,0 @@
-#!/usr/bin/env bash
-
-echo "$(echo '<rubrikk>'; cat $1)" > "$1"
-echo "</rubrikk>" >> "$1"
\ No newline at end of file
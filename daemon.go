@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"bitbucket.org/waseka/waseka-xml-generator/exporter"
+	"bitbucket.org/waseka/waseka-xml-generator/metrics"
+	"bitbucket.org/waseka/waseka-xml-generator/runlock"
+	"bitbucket.org/waseka/waseka-xml-generator/scheduler"
+	"bitbucket.org/waseka/waseka-xml-generator/utils"
+)
+
+// schedule of the full run when "SCHEDULE" is not set in .env file
+const DEFAULT_SCHEDULE = "0 * * * *"
+
+// scheduleKey turns a category or an export target into its .env key,
+// e.g. SCHEDULE_RESIDENTIAL_FOR_SALE or SCHEDULE_EXPORT_S3
+func scheduleKey(name string) string {
+	return "SCHEDULE_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// daemon runs the parser and the exporters on cron schedules until SIGTERM or SIGINT.
+// "SCHEDULE" runs every category and export target which has no schedule of its own,
+// "SCHEDULE_<CATEGORY>" parses a single category and "SCHEDULE_EXPORT_<TARGET>" exports
+// to a single target
+func daemon() {
+	go func() {
+		if err := metrics.ListenAndServe(); err != nil {
+			fmt.Println("Unable to serve metrics - " + err.Error())
+		}
+	}()
+
+	s := scheduler.New(utils.EnvDuration("DAEMON_JITTER", 0), utils.EnvDuration("DAEMON_BACKOFF", scheduler.DEFAULT_BACKOFF))
+	isExportable := os.Getenv("IS_EXPORTABLE") == "true"
+
+	var defaultTargets []string
+	for _, target := range exporter.Targets() {
+		expression := os.Getenv(scheduleKey("export-" + target))
+		if expression == "" || !isExportable {
+			defaultTargets = append(defaultTargets, target)
+			continue
+		}
+
+		targets := []string{target}
+		addJob(s, "export to "+target, expression, func() error {
+			return exporter.Export(targets)
+		})
+	}
+
+	if !isExportable {
+		defaultTargets = nil
+	}
+
+	var defaultCategories []string
+	for category := range utils.PropertyTableMap {
+		expression := os.Getenv(scheduleKey(category))
+		if expression == "" {
+			defaultCategories = append(defaultCategories, category)
+			continue
+		}
+
+		categories := []string{category}
+		addJob(s, "parse "+category, expression, func() error {
+			generate(categories, defaultTargets)
+			return nil
+		})
+	}
+
+	if len(defaultCategories) > 0 {
+		expression := os.Getenv("SCHEDULE")
+		if expression == "" {
+			expression = DEFAULT_SCHEDULE
+		}
+
+		addJob(s, "parse "+strings.Join(defaultCategories, ", "), expression, func() error {
+			generate(defaultCategories, defaultTargets)
+			return nil
+		})
+	}
+
+	// the current job is finished before the daemon stops
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	s.Run(ctx)
+	fmt.Println("Daemon stopped")
+}
+
+// addJob runs the job under the run lock, a job finding another run in progress, e.g. a
+// one-shot --type=parse or a daemon on another host, fails and is retried with backoff
+func addJob(s *scheduler.Scheduler, name string, expression string, run func() error) {
+	err := s.Add(name, expression, func() error {
+		lock, err := runlock.Acquire()
+		if err != nil {
+			return err
+		}
+		defer lock.Release()
+
+		return run()
+	})
+
+	if err != nil {
+		panic(err.Error())
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"bitbucket.org/waseka/waseka-xml-generator/feed"
+)
+
+// diff prints the added, removed and changed adverts between two generations of the feeds,
+// e.g. --old=$EXPORT_PATH/releases/<release> --new=feeds
+func diff(oldSource string, newSource string, format string, outputPath string) {
+	if oldSource == "" {
+		log.Fatal("Old feeds are required, e.g. --type=diff --old=<dir|url> --new=<dir|url>")
+	}
+	if newSource == "" {
+		newSource = "feeds"
+	}
+	if format != "text" && format != "json" {
+		log.Fatalf("Unknown format %q, expected text or json", format)
+	}
+
+	result, err := feed.CompareSources(oldSource, newSource)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	output := []byte(result.Text())
+	if format == "json" {
+		output, err = json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			panic(err.Error())
+		}
+		output = append(output, "\n"...)
+	}
+
+	if outputPath == "" {
+		fmt.Print(string(output))
+		return
+	}
+
+	if err = os.WriteFile(outputPath, output, 0644); err != nil {
+		panic(err.Error())
+	}
+	fmt.Println("Diff written to " + outputPath)
+}
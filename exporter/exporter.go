@@ -0,0 +1,97 @@
+package exporter
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"bitbucket.org/waseka/waseka-xml-generator/manifest"
+	"bitbucket.org/waseka/waseka-xml-generator/metrics"
+	"bitbucket.org/waseka/waseka-xml-generator/utils"
+)
+
+// RootFiles are published by every export target next to the "ExportDirectories" of utils
+var RootFiles = []string{
+	"feed.xml",
+	manifest.FILE_NAME,
+	manifest.SIGNATURE_FILE_NAME,
+}
+
+var exportDuration = metrics.NewHistogram("xml_generator_export_duration_seconds", "Duration of the exports by target", []float64{1, 5, 10, 30, 60, 120, 300, 600, 1800}, "target")
+var exportsTotal = metrics.NewCounter("xml_generator_exports_total", "Exports by target and result, success or failure", "target", "result")
+
+// Exporter publishes the generated feeds, the feed.xml index and the other
+// "ExportDirectories" of utils to an export target
+type Exporter interface {
+	Name() string
+	Export() error
+}
+
+func New(target string) (Exporter, error) {
+	switch target {
+	case "local":
+		return NewLocal(), nil
+	case "s3":
+		return NewS3(), nil
+	case "sftp":
+		return NewSFTP(), nil
+	}
+
+	return nil, fmt.Errorf("Invalid export target %q, target should contains only - local, s3, sftp", target)
+}
+
+// Targets reads "EXPORT_TARGETS" from .env file as a comma separated list, defaults to local.
+// The local target moves feed.xml and the manifest away so it is always exported last
+func Targets() []string {
+	value := os.Getenv("EXPORT_TARGETS")
+	if value == "" {
+		return []string{"local"}
+	}
+
+	var targets []string
+	hasLocal := false
+	for _, target := range strings.Split(value, ",") {
+		target = strings.ToLower(strings.TrimSpace(target))
+		if target == "local" {
+			hasLocal = true
+			continue
+		}
+		if target != "" {
+			targets = append(targets, target)
+		}
+	}
+
+	if hasLocal {
+		targets = append(targets, "local")
+	}
+	return targets
+}
+
+// Export compresses the feeds and exports them to the targets, every target writes the signed
+// manifest of the files it publishes
+func Export(targets []string) error {
+	utils.CompressFeeds("feeds")
+
+	// every target publishes the run as the same release
+	runRelease = NewRelease(time.Now())
+
+	for _, target := range targets {
+		exporter, err := New(target)
+		if err != nil {
+			return err
+		}
+
+		exportStart := time.Now()
+		err = exporter.Export()
+		exportDuration.Observe(time.Since(exportStart).Seconds(), exporter.Name())
+
+		if err != nil {
+			exportsTotal.Inc(exporter.Name(), "failure")
+			return fmt.Errorf("%s export failed - %s", exporter.Name(), err.Error())
+		}
+		exportsTotal.Inc(exporter.Name(), "success")
+	}
+
+	return nil
+}
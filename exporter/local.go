@@ -0,0 +1,231 @@
+package exporter
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"bitbucket.org/waseka/waseka-xml-generator/manifest"
+	"bitbucket.org/waseka/waseka-xml-generator/utils"
+)
+
+// Local publishes every run as "EXPORT_PATH/releases/<release>" and repoints the
+// "EXPORT_PATH/current" symlink to it. "EXPORT_PATH/feeds", "EXPORT_PATH/feed.xml", ...
+// are symlinks through current, so the public URLs never change between releases
+type Local struct {
+	ExportPath string
+	Retention  int
+}
+
+func NewLocal() *Local {
+	return &Local{
+		ExportPath: os.Getenv("EXPORT_PATH"),
+		Retention:  releaseRetention(),
+	}
+}
+
+func (l *Local) Name() string {
+	return "local"
+}
+
+func (l *Local) releasesPath() string {
+	return l.ExportPath + "/releases"
+}
+
+func (l *Local) Export() error {
+	utils.CreatePublicXmlFile(os.Getenv("APP_URL") + "/feeds/")
+	if err := manifest.Create(); err != nil {
+		return err
+	}
+
+	release := releaseName()
+	releasePath := l.releasesPath() + "/" + release
+	if err := os.MkdirAll(l.releasesPath(), 0777); err != nil {
+		return err
+	}
+	// a release is never written twice
+	if err := os.Mkdir(releasePath, 0777); err != nil {
+		return err
+	}
+
+	for _, fileName := range RootFiles {
+		if _, err := os.Stat(fileName); err != nil {
+			continue
+		}
+
+		// Moving feed.xml, manifest.json, ... to the release
+		if err := os.Rename(fileName, releasePath+"/"+fileName); err != nil {
+			return err
+		}
+
+		// Giving the file permission
+		if err := os.Chmod(releasePath+"/"+fileName, 0777); err != nil {
+			return err
+		}
+	}
+
+	for _, dirName := range utils.ExportDirectories {
+		// directories of disabled outputs are not generated
+		if _, err := os.Stat(dirName); err != nil {
+			continue
+		}
+
+		// the working directories are kept for the daemon, which regenerates only the
+		// categories due and exports them together with the feeds of the other categories
+		if err := linkDirectory(dirName, releasePath+"/"+dirName); err != nil {
+			return err
+		}
+
+		if err := os.Chmod(releasePath+"/"+dirName, 0777); err != nil {
+			return err
+		}
+	}
+
+	if err := l.Rollback(release); err != nil {
+		return err
+	}
+
+	return l.prune()
+}
+
+// linkDirectory hard links every file of the directory into the release, the files are
+// copied when the release is on another filesystem
+func linkDirectory(dirName string, releaseDirName string) error {
+	files, err := ioutil.ReadDir(dirName)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(releaseDirName, 0777); err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+
+		src := dirName + "/" + file.Name()
+		dst := releaseDirName + "/" + file.Name()
+		if err = os.Link(src, dst); err == nil {
+			continue
+		}
+
+		if err = copyFile(src, dst); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0777)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Releases lists the published releases from the oldest to the newest
+func (l *Local) Releases() ([]string, error) {
+	files, err := ioutil.ReadDir(l.releasesPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var releases []string
+	for _, file := range files {
+		if file.IsDir() {
+			releases = append(releases, file.Name())
+		}
+	}
+	sort.Strings(releases)
+
+	return releases, nil
+}
+
+// Current returns the release the "current" symlink points to
+func (l *Local) Current() string {
+	target, err := os.Readlink(l.ExportPath + "/current")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(target, "releases/")
+}
+
+// Rollback repoints the "current" symlink to the release. The new symlink is renamed over
+// the old one, so consumers switch from one complete set of feeds to the other at once
+func (l *Local) Rollback(release string) error {
+	if err := hasRelease(l, release); err != nil {
+		return err
+	}
+
+	tempLink := l.ExportPath + "/.current.tmp"
+	os.Remove(tempLink)
+	if err := os.Symlink("releases/"+release, tempLink); err != nil {
+		return err
+	}
+	if err := os.Rename(tempLink, l.ExportPath+"/current"); err != nil {
+		return err
+	}
+
+	return l.linkPublicPaths()
+}
+
+// linkPublicPaths points "EXPORT_PATH/feeds", "EXPORT_PATH/feed.xml", ... to current. The
+// directories of exports made before releases existed are replaced once by the symlinks
+func (l *Local) linkPublicPaths() error {
+	for _, name := range append(append([]string{}, RootFiles...), utils.ExportDirectories...) {
+		publicPath := l.ExportPath + "/" + name
+		if target, err := os.Readlink(publicPath); err == nil && target == "current/"+name {
+			continue
+		}
+
+		if err := os.RemoveAll(publicPath); err != nil {
+			return err
+		}
+		if err := os.Symlink("current/"+name, publicPath); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// prune keeps the newest "RELEASE_RETENTION" releases, the current release is never removed
+func (l *Local) prune() error {
+	releases, err := l.Releases()
+	if err != nil {
+		return err
+	}
+
+	current := l.Current()
+	for i := 0; i < len(releases)-l.Retention; i++ {
+		if releases[i] == current {
+			continue
+		}
+
+		if err = os.RemoveAll(l.releasesPath() + "/" + releases[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package exporter
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// number of releases kept when "RELEASE_RETENTION" is not set in .env file
+const DEFAULT_RELEASE_RETENTION = 5
+
+// Releaser is an export target which keeps the previous releases, --type=rollback points
+// its consumers back to one of them
+type Releaser interface {
+	Name() string
+	Releases() ([]string, error)
+	Current() string
+	Rollback(release string) error
+}
+
+// runRelease is shared by the targets of an export, so a release of a run has the same
+// name on every target
+var runRelease string
+
+// NewRelease names a release by its time down to the nanosecond, two runs in the same
+// second never write to the same release and the names sort from the oldest to the newest
+func NewRelease(now time.Time) string {
+	return now.Format("20060102150405.000000000")
+}
+
+func releaseName() string {
+	if runRelease == "" {
+		runRelease = NewRelease(time.Now())
+	}
+	return runRelease
+}
+
+func releaseRetention() int {
+	retention, err := strconv.Atoi(os.Getenv("RELEASE_RETENTION"))
+	if err != nil || retention <= 0 {
+		return DEFAULT_RELEASE_RETENTION
+	}
+	return retention
+}
+
+// hasRelease only accepts a listed release, e.g. --to=../.. is refused
+func hasRelease(r Releaser, release string) error {
+	releases, err := r.Releases()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range releases {
+		if name == release {
+			return nil
+		}
+	}
+	return fmt.Errorf("release %q does not exist on %s, available releases - %s", release, r.Name(), strings.Join(releases, ", "))
+}
+
+// Releasers returns the targets keeping releases, the local and s3 targets
+func Releasers(targets []string) ([]Releaser, error) {
+	var releasers []Releaser
+	for _, target := range targets {
+		exporter, err := New(target)
+		if err != nil {
+			return nil, err
+		}
+		if r, ok := exporter.(Releaser); ok {
+			releasers = append(releasers, r)
+		}
+	}
+	return releasers, nil
+}
+
+// Rollback points every target back to the release, the release must exist on every
+// target before any of them is changed
+func Rollback(targets []string, release string) error {
+	releasers, err := Releasers(targets)
+	if err != nil {
+		return err
+	}
+	if len(releasers) == 0 {
+		return fmt.Errorf("none of the export targets %s keeps releases", strings.Join(targets, ", "))
+	}
+
+	for _, r := range releasers {
+		if err := hasRelease(r, release); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range releasers {
+		if err := r.Rollback(release); err != nil {
+			return fmt.Errorf("%s rollback failed - %s", r.Name(), err.Error())
+		}
+	}
+	return nil
+}
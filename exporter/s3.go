@@ -0,0 +1,443 @@
+package exporter
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"bitbucket.org/waseka/waseka-xml-generator/manifest"
+	"bitbucket.org/waseka/waseka-xml-generator/utils"
+)
+
+var ContentTypeMap = map[string]string{
+	".xml":  "application/xml; charset=utf-8",
+	".gz":   "application/gzip",
+	".zst":  "application/zstd",
+	".json": "application/json",
+	".sig":  "text/plain; charset=utf-8",
+}
+
+// S3 uploads every run to "<S3_PREFIX>/releases/<release>/" of an S3-compatible bucket (AWS,
+// MinIO) and only then switches "<S3_PREFIX>/feed.xml" to the new release, so partners never
+// see a half uploaded set of feeds. The newest "RELEASE_RETENTION" releases are kept
+type S3 struct {
+	Endpoint     string
+	Region       string
+	Bucket       string
+	AccessKey    string
+	SecretKey    string
+	Prefix       string
+	PublicURL    string
+	CacheControl string
+	Retention    int
+	client       *http.Client
+}
+
+func NewS3() *S3 {
+	s := &S3{
+		Endpoint:     strings.TrimRight(os.Getenv("S3_ENDPOINT"), "/"),
+		Region:       os.Getenv("S3_REGION"),
+		Bucket:       os.Getenv("S3_BUCKET"),
+		AccessKey:    os.Getenv("S3_ACCESS_KEY"),
+		SecretKey:    os.Getenv("S3_SECRET_KEY"),
+		Prefix:       strings.Trim(os.Getenv("S3_PREFIX"), "/"),
+		PublicURL:    strings.TrimRight(os.Getenv("S3_PUBLIC_URL"), "/"),
+		CacheControl: os.Getenv("S3_CACHE_CONTROL"),
+		Retention:    releaseRetention(),
+		client:       &http.Client{Timeout: 10 * time.Minute},
+	}
+
+	if s.Region == "" {
+		s.Region = "us-east-1"
+	}
+	if s.PublicURL == "" {
+		s.PublicURL = s.Endpoint + "/" + s.Bucket
+	}
+	if s.CacheControl == "" {
+		s.CacheControl = "public, max-age=300"
+	}
+	return s
+}
+
+func (s *S3) Name() string {
+	return "s3"
+}
+
+func (s *S3) key(path string) string {
+	if s.Prefix == "" {
+		return path
+	}
+	return s.Prefix + "/" + path
+}
+
+func (s *S3) Export() error {
+	if s.Endpoint == "" || s.Bucket == "" {
+		return fmt.Errorf("S3_ENDPOINT and S3_BUCKET are required in .env file")
+	}
+
+	release := "releases/" + releaseName()
+
+	for _, dirName := range utils.ExportDirectories {
+		files, err := ioutil.ReadDir(dirName)
+		if err != nil {
+			// directories of disabled outputs are not generated
+			continue
+		}
+
+		for _, file := range files {
+			if file.IsDir() {
+				continue
+			}
+
+			filePath := dirName + "/" + file.Name()
+			if err = s.Upload(filePath, s.key(release+"/"+filePath), s.CacheControl); err != nil {
+				return err
+			}
+		}
+	}
+
+	utils.CreatePublicXmlFile(s.PublicURL + "/" + s.key(release+"/feeds/"))
+	if _, err := os.Stat("feed.xml"); err != nil {
+		return nil
+	}
+	defer os.Remove("feed.xml")
+
+	if err := manifest.Create(); err != nil {
+		return err
+	}
+
+	// the copies inside the release keep the index and the manifest of every release
+	// for a later rollback
+	for _, fileName := range RootFiles {
+		if _, err := os.Stat(fileName); err != nil {
+			continue
+		}
+		if err := s.Upload(fileName, s.key(release+"/"+fileName), s.CacheControl); err != nil {
+			return err
+		}
+	}
+
+	// switching the manifest is the last upload, so the new release goes live at once
+	if err := s.Upload("feed.xml", s.key("feed.xml"), "no-cache"); err != nil {
+		return err
+	}
+
+	return s.prune(releaseName())
+}
+
+// Upload puts a local file to the bucket with a signature version 4 request
+func (s *S3) Upload(filePath string, key string, cacheControl string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	contentType, ok := ContentTypeMap[filepath.Ext(filePath)]
+	if !ok {
+		contentType = "application/octet-stream"
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", contentType)
+	header.Set("Cache-Control", cacheControl)
+
+	res, err := s.do(http.MethodPut, key, nil, header, f, info.Size(), utils.FileChecksum(filePath))
+	if err != nil {
+		return fmt.Errorf("upload of %s failed - %s", key, err.Error())
+	}
+	res.Body.Close()
+	return nil
+}
+
+// do sends a signed request for the object key, or for the bucket when the key is empty,
+// a response other than 200 or 204 is returned as an error
+func (s *S3) do(method string, key string, query url.Values, header http.Header, body io.Reader, size int64, payloadHash string) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.Endpoint+"/"+s.Bucket+"/"+encodePath(key), body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	req.URL.RawQuery = encodeQuery(query)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	s.sign(req, payloadHash, time.Now().UTC())
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNoContent {
+		defer res.Body.Close()
+		responseBody, _ := ioutil.ReadAll(res.Body)
+		return nil, fmt.Errorf("status %d - %s", res.StatusCode, string(responseBody))
+	}
+	return res, nil
+}
+
+type listBucketResult struct {
+	Keys                  []string `xml:"Contents>Key"`
+	Prefixes              []string `xml:"CommonPrefixes>Prefix"`
+	IsTruncated           bool     `xml:"IsTruncated"`
+	NextContinuationToken string   `xml:"NextContinuationToken"`
+}
+
+// list returns the keys under the prefix, or only the common prefixes up to the next "/"
+// when folders is true
+func (s *S3) list(prefix string, folders bool) ([]string, error) {
+	var names []string
+	token := ""
+
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if folders {
+			query.Set("delimiter", "/")
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		res, err := s.do(http.MethodGet, "", query, nil, nil, 0, hashHex(nil))
+		if err != nil {
+			return nil, fmt.Errorf("listing of %s failed - %s", prefix, err.Error())
+		}
+
+		var result listBucketResult
+		err = xml.NewDecoder(res.Body).Decode(&result)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if folders {
+			names = append(names, result.Prefixes...)
+		} else {
+			names = append(names, result.Keys...)
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return names, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// Releases lists the uploaded releases from the oldest to the newest
+func (s *S3) Releases() ([]string, error) {
+	prefix := s.key("releases/")
+	folders, err := s.list(prefix, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var releases []string
+	for _, folder := range folders {
+		releases = append(releases, strings.TrimSuffix(strings.TrimPrefix(folder, prefix), "/"))
+	}
+	sort.Strings(releases)
+
+	return releases, nil
+}
+
+var releasePattern = regexp.MustCompile(`/releases/([^/<]+)/feeds/`)
+
+// Current returns the release the feed URLs of "<S3_PREFIX>/feed.xml" point to
+func (s *S3) Current() string {
+	res, err := s.do(http.MethodGet, s.key("feed.xml"), nil, nil, nil, 0, hashHex(nil))
+	if err != nil {
+		return ""
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return ""
+	}
+
+	match := releasePattern.FindSubmatch(body)
+	if match == nil {
+		return ""
+	}
+	return string(match[1])
+}
+
+// Rollback copies the feed.xml kept in the release over "<S3_PREFIX>/feed.xml", so the
+// partners download the feeds of the release again
+func (s *S3) Rollback(release string) error {
+	if err := hasRelease(s, release); err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", ContentTypeMap[".xml"])
+	header.Set("Cache-Control", "no-cache")
+	header.Set("X-Amz-Copy-Source", "/"+s.Bucket+"/"+encodePath(s.key("releases/"+release+"/feed.xml")))
+	header.Set("X-Amz-Metadata-Directive", "REPLACE")
+
+	res, err := s.do(http.MethodPut, s.key("feed.xml"), nil, header, nil, 0, hashHex(nil))
+	if err != nil {
+		return fmt.Errorf("copy of feed.xml of release %s failed - %s", release, err.Error())
+	}
+	res.Body.Close()
+	return nil
+}
+
+// prune keeps the newest "RELEASE_RETENTION" releases, the current release is never removed
+func (s *S3) prune(current string) error {
+	releases, err := s.Releases()
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(releases)-s.Retention; i++ {
+		if releases[i] == current {
+			continue
+		}
+
+		keys, err := s.list(s.key("releases/"+releases[i]+"/"), false)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			res, err := s.do(http.MethodDelete, key, nil, nil, nil, 0, hashHex(nil))
+			if err != nil {
+				return fmt.Errorf("delete of %s failed - %s", key, err.Error())
+			}
+			res.Body.Close()
+		}
+	}
+
+	return nil
+}
+
+// sign adds the AWS signature version 4 authorization header to the request
+func (s *S3) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// every header set on the request is signed, the transport only adds the user agent,
+	// the content length and the encoding which are not
+	headers := map[string]string{
+		"host": req.URL.Host,
+	}
+	for name := range req.Header {
+		headers[strings.ToLower(name)] = req.Header.Get(name)
+	}
+
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalURI := encodePath(req.URL.Path)
+	if canonicalURI == "" {
+		canonicalURI = "/"
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI,
+		encodeQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hashHex([]byte(canonicalRequest)),
+	}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	signingKey = hmacSHA256(signingKey, s.Region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.AccessKey+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func hashHex(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// uriEncode escapes every byte but the unreserved characters A-Z, a-z, 0-9, "-", ".", "_"
+// and "~" as signature version 4 requires, the slashes of an object key are kept. url.PathEscape
+// keeps "$", "=", "@" and ":" which S3 escapes when it verifies the signature
+func uriEncode(value string, keepSlash bool) string {
+	var encoded strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~' || c == '/' && keepSlash {
+			encoded.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&encoded, "%%%02X", c)
+	}
+	return encoded.String()
+}
+
+// encodeQuery sorts and escapes the query as required by signature version 4, a space is
+// %20 and not +
+func encodeQuery(query url.Values) string {
+	var names []string
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var pairs []string
+	for _, name := range names {
+		values := append([]string(nil), query[name]...)
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(name, false)+"="+uriEncode(value, false))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// encodePath escapes an object key, the request is sent with the path it is signed with
+func encodePath(key string) string {
+	return uriEncode(key, true)
+}
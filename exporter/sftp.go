@@ -0,0 +1,301 @@
+package exporter
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"bitbucket.org/waseka/waseka-xml-generator/manifest"
+	"bitbucket.org/waseka/waseka-xml-generator/utils"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// feedFilePattern matches the feeds on the server of a partner, e.g. feed1.xml or feed1-002.xml.gz
+var feedFilePattern = regexp.MustCompile(`^feed\d+[-.]`)
+
+// SFTP pushes the feeds to the SFTP server of every partner of "SFTP_TARGETS" of .env file
+type SFTP struct {
+	Partners []SFTPPartner
+}
+
+// SFTPPartner is configured by "SFTP_<NAME>_*" keys of .env file, e.g. SFTP_ACME_HOST for acme
+type SFTPPartner struct {
+	Name         string
+	Host         string
+	Port         string
+	User         string
+	Password     string
+	KeyFile      string
+	KnownHosts   string
+	InsecureHost bool
+	RemoteDir    string
+	Categories   []string
+}
+
+func NewSFTP() *SFTP {
+	s := &SFTP{}
+
+	for _, name := range strings.Split(os.Getenv("SFTP_TARGETS"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		env := func(key string) string {
+			return os.Getenv("SFTP_" + strings.ToUpper(name) + "_" + key)
+		}
+
+		partner := SFTPPartner{
+			Name:         name,
+			Host:         env("HOST"),
+			Port:         env("PORT"),
+			User:         env("USER"),
+			Password:     env("PASSWORD"),
+			KeyFile:      env("KEY_FILE"),
+			KnownHosts:   env("KNOWN_HOSTS"),
+			InsecureHost: env("INSECURE_HOST_KEY") == "true",
+			RemoteDir:    env("REMOTE_DIR"),
+		}
+
+		if partner.Port == "" {
+			partner.Port = "22"
+		}
+		if partner.RemoteDir == "" {
+			partner.RemoteDir = "."
+		}
+
+		// every category is pushed unless "SFTP_<NAME>_CATEGORIES" narrows it down
+		for _, category := range strings.Split(env("CATEGORIES"), ",") {
+			if category = strings.TrimSpace(category); category != "" {
+				partner.Categories = append(partner.Categories, category)
+			}
+		}
+
+		s.Partners = append(s.Partners, partner)
+	}
+
+	return s
+}
+
+func (s *SFTP) Name() string {
+	return "sftp"
+}
+
+func (s *SFTP) Export() error {
+	if len(s.Partners) == 0 {
+		return fmt.Errorf("SFTP_TARGETS is required in .env file")
+	}
+
+	files, err := ioutil.ReadDir("feeds")
+	if err != nil {
+		return err
+	}
+
+	for _, partner := range s.Partners {
+		var fileNames []string
+		for _, file := range files {
+			if partner.accepts(utils.CategoryByFileName(file.Name())) {
+				fileNames = append(fileNames, file.Name())
+			}
+		}
+
+		if err = partner.push(fileNames); err != nil {
+			return fmt.Errorf("%s - %s", partner.Name, err.Error())
+		}
+	}
+
+	return nil
+}
+
+func (p SFTPPartner) accepts(category string) bool {
+	if len(p.Categories) == 0 {
+		return true
+	}
+
+	for _, partnerCategory := range p.Categories {
+		if partnerCategory == category {
+			return true
+		}
+	}
+	return false
+}
+
+func (p SFTPPartner) clientConfig() (*ssh.ClientConfig, error) {
+	config := &ssh.ClientConfig{
+		User:    p.User,
+		Timeout: 30 * time.Second,
+	}
+
+	if p.KeyFile != "" {
+		key, err := ioutil.ReadFile(p.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		config.Auth = append(config.Auth, ssh.PublicKeys(signer))
+	}
+	if p.Password != "" {
+		config.Auth = append(config.Auth, ssh.Password(p.Password))
+	}
+
+	if p.InsecureHost {
+		config.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+		return config, nil
+	}
+
+	knownHostsFile := p.KnownHosts
+	if knownHostsFile == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		knownHostsFile = home + "/.ssh/known_hosts"
+	}
+
+	hostKeyCallback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, err
+	}
+	config.HostKeyCallback = hostKeyCallback
+
+	return config, nil
+}
+
+func (p SFTPPartner) push(fileNames []string) error {
+	config, err := p.clientConfig()
+	if err != nil {
+		return err
+	}
+
+	conn, err := ssh.Dial("tcp", net.JoinHostPort(p.Host, p.Port), config)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err = client.MkdirAll(p.RemoteDir); err != nil {
+		return err
+	}
+
+	for _, fileName := range fileNames {
+		if err = upload(client, "feeds/"+fileName, path.Join(p.RemoteDir, fileName)); err != nil {
+			return err
+		}
+	}
+
+	if err = p.pushManifest(client, fileNames); err != nil {
+		return err
+	}
+
+	return p.removeStale(client, fileNames)
+}
+
+// removeStale removes the feeds which are no longer pushed to the partner, e.g. feed1-003.xml
+// of a category which shrank to two parts or the feeds of a category removed from
+// "SFTP_<NAME>_CATEGORIES", so the partner never reads them as current feeds
+func (p SFTPPartner) removeStale(client *sftp.Client, fileNames []string) error {
+	pushed := make(map[string]bool)
+	for _, fileName := range fileNames {
+		pushed[fileName] = true
+	}
+
+	files, err := client.ReadDir(p.RemoteDir)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if file.IsDir() || pushed[file.Name()] || !feedFilePattern.MatchString(file.Name()) {
+			continue
+		}
+		if err = client.Remove(path.Join(p.RemoteDir, file.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pushManifest uploads the signed manifest of the feeds of the partner after the feeds, the
+// paths are relative to the remote directory so the partner verifies it with --type=verify
+func (p SFTPPartner) pushManifest(client *sftp.Client, fileNames []string) error {
+	tempDir, err := ioutil.TempDir("", "sftp-manifest")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	var entries []manifest.Entry
+	for _, fileName := range fileNames {
+		entries = append(entries, manifest.Entry{Path: fileName, LocalPath: "feeds/" + fileName})
+	}
+
+	manifestPath := tempDir + "/" + manifest.FILE_NAME
+	signaturePath := tempDir + "/" + manifest.SIGNATURE_FILE_NAME
+	if err = manifest.Write(manifestPath, signaturePath, entries); err != nil {
+		return err
+	}
+
+	// the signature of an unsigned manifest is removed, so a stale signature never
+	// stays next to a new manifest
+	if _, err = os.Stat(signaturePath); err == nil {
+		if err = upload(client, signaturePath, path.Join(p.RemoteDir, manifest.SIGNATURE_FILE_NAME)); err != nil {
+			return err
+		}
+	} else {
+		client.Remove(path.Join(p.RemoteDir, manifest.SIGNATURE_FILE_NAME))
+	}
+
+	return upload(client, manifestPath, path.Join(p.RemoteDir, manifest.FILE_NAME))
+}
+
+// upload writes to a hidden temporary file first and renames it over the feed, so the
+// partner never picks up a partially written feed
+func upload(client *sftp.Client, filePath string, remotePath string) error {
+	src, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tempPath := path.Join(path.Dir(remotePath), "."+path.Base(remotePath)+".tmp")
+	dst, err := client.Create(tempPath)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err = dst.Close(); err != nil {
+		return err
+	}
+
+	// servers without the posix-rename extension refuse to rename over an existing file
+	if err = client.PosixRename(tempPath, remotePath); err != nil {
+		client.Remove(remotePath)
+		return client.Rename(tempPath, remotePath)
+	}
+
+	return nil
+}
@@ -0,0 +1,384 @@
+package feed
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+// Diff of two generations of the feeds, the adverts are matched by category and
+// ad__number_reference_id
+type Diff struct {
+	Old        string         `json:"old"`
+	New        string         `json:"new"`
+	Categories []CategoryDiff `json:"categories"`
+}
+
+type CategoryDiff struct {
+	Category   string          `json:"category"`
+	OldAdverts int             `json:"old_adverts"`
+	NewAdverts int             `json:"new_adverts"`
+	Added      []AdvertRef     `json:"added"`
+	Removed    []AdvertRef     `json:"removed"`
+	Changed    []ChangedItem   `json:"changed"`
+	Invalid    []InvalidAdvert `json:"invalid"`
+}
+
+// AdvertRef identifies an added or removed advert
+type AdvertRef struct {
+	Id         int    `json:"id"`
+	AdHeadline string `json:"headline"`
+	URL        string `json:"url"`
+}
+
+type ChangedItem struct {
+	AdvertRef
+	Fields []FieldChange `json:"fields"`
+}
+
+// FieldChange of an advert by its element name, e.g. ad__price, the images are compared
+// as lists with the added and removed images. An advert moved to another category has a
+// category change
+type FieldChange struct {
+	Field   string      `json:"field"`
+	Old     interface{} `json:"old"`
+	New     interface{} `json:"new"`
+	Added   []string    `json:"added,omitempty"`
+	Removed []string    `json:"removed,omitempty"`
+}
+
+// InvalidAdvert of the old or new feeds which can not be decoded, e.g. a price which is not
+// a number. The id is known when it precedes the invalid value, such an advert is neither
+// added nor removed
+type InvalidAdvert struct {
+	Generation string `json:"generation"`
+	Feed       string `json:"feed"`
+	Line       int    `json:"line"`
+	Id         int    `json:"id,omitempty"`
+	Error      string `json:"error"`
+}
+
+func ref(advert Advert) AdvertRef {
+	return AdvertRef{Id: advert.Id, AdHeadline: advert.AdHeadline, URL: advert.URL}
+}
+
+// generation of the feeds, the adverts of an id by category
+type generation struct {
+	adverts map[int]map[string]Advert
+	invalid map[int]map[string]bool
+}
+
+func newGeneration() generation {
+	return generation{adverts: map[int]map[string]Advert{}, invalid: map[int]map[string]bool{}}
+}
+
+func (g generation) add(category string, advert Advert) {
+	if g.adverts[advert.Id] == nil {
+		g.adverts[advert.Id] = map[string]Advert{}
+	}
+	g.adverts[advert.Id][category] = advert
+}
+
+func (g generation) addInvalid(category string, id int) {
+	if g.invalid[id] == nil {
+		g.invalid[id] = map[string]bool{}
+	}
+	g.invalid[id][category] = true
+}
+
+// take removes the advert of the id of the category, or of any category when category
+// is empty, so the adverts left are the removed adverts
+func (g generation) take(category string, id int) (string, Advert, bool) {
+	for advertCategory, advert := range g.adverts[id] {
+		if category != "" && advertCategory != category {
+			continue
+		}
+
+		delete(g.adverts[id], advertCategory)
+		if len(g.adverts[id]) == 0 {
+			delete(g.adverts, id)
+		}
+		return advertCategory, advert, true
+	}
+	return "", Advert{}, false
+}
+
+// CompareSources streams the feeds of both sources, see Files. The adverts of the old
+// source are kept by ad__number_reference_id while the feeds of the new source are read.
+// An id is matched within its category first, so an id used by several categories is not
+// mixed up, and then in the other categories, an advert moved to another category is
+// changed and reported in its new category
+func CompareSources(oldSource string, newSource string) (Diff, error) {
+	diff := Diff{Old: oldSource, New: newSource}
+
+	categoryDiffs := map[string]*CategoryDiff{}
+	categoryDiff := func(category string) *CategoryDiff {
+		d, ok := categoryDiffs[category]
+		if !ok {
+			d = &CategoryDiff{Category: category, Added: []AdvertRef{}, Removed: []AdvertRef{}, Changed: []ChangedItem{}, Invalid: []InvalidAdvert{}}
+			categoryDiffs[category] = d
+		}
+		return d
+	}
+
+	oldFiles, err := Files(oldSource)
+	if err != nil {
+		return diff, err
+	}
+	newFiles, err := Files(newSource)
+	if err != nil {
+		return diff, err
+	}
+
+	old := newGeneration()
+	oldIds := map[string]map[int]bool{}
+	for _, file := range oldFiles {
+		d := categoryDiff(file.Category)
+		if oldIds[file.Category] == nil {
+			oldIds[file.Category] = map[int]bool{}
+		}
+
+		err := eachAdvert(file, func(advert Advert) {
+			oldIds[file.Category][advert.Id] = true
+			old.add(file.Category, advert)
+		}, func(invalid InvalidAdvert) {
+			invalid.Generation = "old"
+			d.Invalid = append(d.Invalid, invalid)
+			if invalid.Id != 0 {
+				old.addInvalid(file.Category, invalid.Id)
+			}
+		})
+		if err != nil {
+			return diff, err
+		}
+	}
+
+	type newAdvert struct {
+		category string
+		advert   Advert
+	}
+	var unmatched []newAdvert
+	invalidNew := newGeneration()
+
+	compare := func(category string, advert Advert, oldCategory string, oldAdvert Advert) {
+		fields := CompareAdverts(oldAdvert, advert)
+		if oldCategory != category {
+			fields = append([]FieldChange{{Field: "category", Old: oldCategory, New: category}}, fields...)
+		}
+		if len(fields) > 0 {
+			d := categoryDiff(category)
+			d.Changed = append(d.Changed, ChangedItem{AdvertRef: ref(advert), Fields: fields})
+		}
+	}
+
+	newIds := map[string]map[int]bool{}
+	for _, file := range newFiles {
+		d := categoryDiff(file.Category)
+		if newIds[file.Category] == nil {
+			newIds[file.Category] = map[int]bool{}
+		}
+
+		err := eachAdvert(file, func(advert Advert) {
+			newIds[file.Category][advert.Id] = true
+
+			if oldCategory, oldAdvert, ok := old.take(file.Category, advert.Id); ok {
+				compare(file.Category, advert, oldCategory, oldAdvert)
+				return
+			}
+			unmatched = append(unmatched, newAdvert{file.Category, advert})
+		}, func(invalid InvalidAdvert) {
+			invalid.Generation = "new"
+			d.Invalid = append(d.Invalid, invalid)
+			if invalid.Id != 0 {
+				invalidNew.addInvalid(file.Category, invalid.Id)
+			}
+		})
+		if err != nil {
+			return diff, err
+		}
+	}
+
+	// an advert which is invalid now is reported as invalid and not as removed
+	for id, categories := range invalidNew.invalid {
+		for category := range categories {
+			old.take(category, id)
+		}
+	}
+
+	for _, n := range unmatched {
+		if oldCategory, oldAdvert, ok := old.take("", n.advert.Id); ok {
+			compare(n.category, n.advert, oldCategory, oldAdvert)
+			continue
+		}
+		if old.invalid[n.advert.Id][n.category] {
+			continue
+		}
+		d := categoryDiff(n.category)
+		d.Added = append(d.Added, ref(n.advert))
+	}
+
+	for _, categories := range old.adverts {
+		for category, advert := range categories {
+			d := categoryDiff(category)
+			d.Removed = append(d.Removed, ref(advert))
+		}
+	}
+
+	for category, ids := range oldIds {
+		categoryDiff(category).OldAdverts = len(ids)
+	}
+	for category, ids := range newIds {
+		categoryDiff(category).NewAdverts = len(ids)
+	}
+
+	var categories []string
+	for category := range categoryDiffs {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+
+	for _, category := range categories {
+		d := categoryDiffs[category]
+		sort.Slice(d.Added, func(i, j int) bool { return d.Added[i].Id < d.Added[j].Id })
+		sort.Slice(d.Removed, func(i, j int) bool { return d.Removed[i].Id < d.Removed[j].Id })
+		sort.Slice(d.Changed, func(i, j int) bool { return d.Changed[i].Id < d.Changed[j].Id })
+		diff.Categories = append(diff.Categories, *d)
+	}
+
+	return diff, nil
+}
+
+// eachAdvert reads the adverts of a feed one at a time, a malformed feed fails the diff and
+// an advert with an invalid value is passed to invalid
+func eachAdvert(file File, fn func(advert Advert), invalid func(invalid InvalidAdvert)) error {
+	body, err := Open(file.Location)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	reader := NewReader(file.Name, body)
+	for {
+		advert, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		var feedErr *Error
+		if errors.As(err, &feedErr) && !feedErr.Malformed {
+			invalid(InvalidAdvert{Feed: file.Name, Line: feedErr.Position.Line, Id: advert.Id, Error: feedErr.Err.Error()})
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		fn(advert)
+	}
+}
+
+// CompareAdverts returns the changed fields of two versions of an advert
+func CompareAdverts(oldAdvert Advert, newAdvert Advert) []FieldChange {
+	var changes []FieldChange
+
+	oldValue := reflect.ValueOf(oldAdvert)
+	newValue := reflect.ValueOf(newAdvert)
+	advertType := oldValue.Type()
+
+	for i := 0; i < advertType.NumField(); i++ {
+		field := advertType.Field(i)
+		if field.Name == "XMLName" {
+			continue
+		}
+
+		name := strings.Split(strings.Split(field.Tag.Get("xml"), ",")[0], ">")[0]
+		oldField := oldValue.Field(i).Interface()
+		newField := newValue.Field(i).Interface()
+
+		switch oldField := oldField.(type) {
+		case decimal.Decimal:
+			if !oldField.Equal(newField.(decimal.Decimal)) {
+				changes = append(changes, FieldChange{Field: name, Old: oldField.String(), New: newField.(decimal.Decimal).String()})
+			}
+		case []string:
+			added, removed := compareLists(oldField, newField.([]string))
+			if len(added) > 0 || len(removed) > 0 || !reflect.DeepEqual(oldField, newField) {
+				changes = append(changes, FieldChange{Field: name, Old: oldField, New: newField, Added: added, Removed: removed})
+			}
+		default:
+			if !reflect.DeepEqual(oldField, newField) {
+				changes = append(changes, FieldChange{Field: name, Old: oldField, New: newField})
+			}
+		}
+	}
+
+	return changes
+}
+
+func compareLists(oldList []string, newList []string) ([]string, []string) {
+	oldItems := map[string]bool{}
+	for _, item := range oldList {
+		oldItems[item] = true
+	}
+	newItems := map[string]bool{}
+	for _, item := range newList {
+		newItems[item] = true
+	}
+
+	var added, removed []string
+	for _, item := range newList {
+		if !oldItems[item] {
+			added = append(added, item)
+		}
+	}
+	for _, item := range oldList {
+		if !newItems[item] {
+			removed = append(removed, item)
+		}
+	}
+	return added, removed
+}
+
+// Text formats the diff for the console, a line per invalid (!), added (+), removed (-) and
+// changed (~) advert after the summary of every category
+func (diff Diff) Text() string {
+	var text strings.Builder
+	fmt.Fprintf(&text, "--- %s\n+++ %s\n", diff.Old, diff.New)
+
+	for _, d := range diff.Categories {
+		fmt.Fprintf(&text, "\n%s - %d -> %d adverts, %d added, %d removed, %d changed, %d invalid\n",
+			d.Category, d.OldAdverts, d.NewAdverts, len(d.Added), len(d.Removed), len(d.Changed), len(d.Invalid))
+
+		for _, advert := range d.Invalid {
+			fmt.Fprintf(&text, "! %s %s:%d %d %s\n", advert.Generation, advert.Feed, advert.Line, advert.Id, advert.Error)
+		}
+
+		for _, advert := range d.Added {
+			fmt.Fprintf(&text, "+ %d %s %s\n", advert.Id, advert.AdHeadline, advert.URL)
+		}
+		for _, advert := range d.Removed {
+			fmt.Fprintf(&text, "- %d %s %s\n", advert.Id, advert.AdHeadline, advert.URL)
+		}
+		for _, advert := range d.Changed {
+			fmt.Fprintf(&text, "~ %d %s %s\n", advert.Id, advert.AdHeadline, advert.URL)
+			for _, field := range advert.Fields {
+				if _, ok := field.Old.([]string); ok {
+					if len(field.Added) == 0 && len(field.Removed) == 0 {
+						fmt.Fprintf(&text, "    %s reordered\n", field.Field)
+						continue
+					}
+					fmt.Fprintf(&text, "    %s +%d -%d\n", field.Field, len(field.Added), len(field.Removed))
+					continue
+				}
+				fmt.Fprintf(&text, "    %s %v -> %v\n", field.Field, field.Old, field.New)
+			}
+		}
+	}
+
+	return text.String()
+}
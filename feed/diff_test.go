@@ -0,0 +1,200 @@
+package feed
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+// writeSource writes the feeds of a source directory, e.g. feed1.xml of residential-for-sale
+func writeSource(t *testing.T, feeds map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, feed := range feeds {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(feed), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func compareFeeds(t *testing.T, oldFeeds map[string]string, newFeeds map[string]string) map[string]CategoryDiff {
+	t.Helper()
+
+	diff, err := CompareSources(writeSource(t, oldFeeds), writeSource(t, newFeeds))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	categories := map[string]CategoryDiff{}
+	for _, d := range diff.Categories {
+		categories[d.Category] = d
+	}
+	return categories
+}
+
+func advert(id int, headline string, price string) Advert {
+	return Advert{Id: id, AdHeadline: headline, URL: "https://example.com/property/" + headline, Price: decimal.RequireFromString(price)}
+}
+
+func refIds(refs []AdvertRef) []int {
+	ids := []int{}
+	for _, r := range refs {
+		ids = append(ids, r.Id)
+	}
+	return ids
+}
+
+func changedFields(changed ChangedItem) []string {
+	var fields []string
+	for _, field := range changed.Fields {
+		fields = append(fields, field.Field)
+	}
+	return fields
+}
+
+func TestCompareSources(t *testing.T) {
+	changed := advert(2, "b", "200")
+	changed.AdvertImages = []string{"https://example.com/images/2-1.jpg"}
+
+	categories := compareFeeds(t,
+		map[string]string{"feed1.xml": writeFeed(t, advert(1, "a", "100"), advert(2, "b", "100"), advert(3, "c", "100"))},
+		map[string]string{"feed1.xml": writeFeed(t, advert(1, "a", "100"), changed, advert(4, "d", "100"))},
+	)
+
+	d := categories["residential-for-sale"]
+	if d.OldAdverts != 3 || d.NewAdverts != 3 {
+		t.Errorf("adverts %d -> %d, want 3 -> 3", d.OldAdverts, d.NewAdverts)
+	}
+	if ids := refIds(d.Added); !reflect.DeepEqual(ids, []int{4}) {
+		t.Errorf("added %v, want [4]", ids)
+	}
+	if ids := refIds(d.Removed); !reflect.DeepEqual(ids, []int{3}) {
+		t.Errorf("removed %v, want [3]", ids)
+	}
+	if len(d.Changed) != 1 || d.Changed[0].Id != 2 {
+		t.Fatalf("changed %+v, want advert 2", d.Changed)
+	}
+	if fields := changedFields(d.Changed[0]); !reflect.DeepEqual(fields, []string{"ad__price", "ad__all_imageurls"}) {
+		t.Errorf("changed fields %v, want ad__price and ad__all_imageurls", fields)
+	}
+	if images := d.Changed[0].Fields[1]; !reflect.DeepEqual(images.Added, []string{"https://example.com/images/2-1.jpg"}) {
+		t.Errorf("added images %v", images.Added)
+	}
+}
+
+func TestCompareSourcesMovedAdvert(t *testing.T) {
+	categories := compareFeeds(t,
+		map[string]string{"feed1.xml": writeFeed(t, advert(5, "e", "100"))},
+		map[string]string{"feed1.xml": writeFeed(t), "feed2.xml": writeFeed(t, advert(5, "e", "100"))},
+	)
+
+	for category, d := range categories {
+		if len(d.Added) > 0 || len(d.Removed) > 0 {
+			t.Errorf("%s: added %v, removed %v, want a moved advert", category, refIds(d.Added), refIds(d.Removed))
+		}
+	}
+
+	d := categories["residential-to-rent"]
+	if len(d.Changed) != 1 || d.Changed[0].Id != 5 {
+		t.Fatalf("changed %+v, want advert 5 in its new category", d.Changed)
+	}
+	move := d.Changed[0].Fields[0]
+	if move.Field != "category" || move.Old != "residential-for-sale" || move.New != "residential-to-rent" {
+		t.Errorf("got %+v, want a category change from residential-for-sale to residential-to-rent", move)
+	}
+	if len(categories["residential-for-sale"].Changed) != 0 {
+		t.Errorf("old category has changes %+v", categories["residential-for-sale"].Changed)
+	}
+}
+
+func TestCompareSourcesSameIdInCategories(t *testing.T) {
+	categories := compareFeeds(t,
+		map[string]string{
+			"feed1.xml": writeFeed(t, advert(7, "sale", "100")),
+			"feed2.xml": writeFeed(t, advert(7, "rent", "10")),
+		},
+		map[string]string{
+			"feed1.xml": writeFeed(t, advert(7, "sale", "100")),
+			"feed2.xml": writeFeed(t, advert(7, "rent", "12")),
+		},
+	)
+
+	if d := categories["residential-for-sale"]; len(d.Added) > 0 || len(d.Removed) > 0 || len(d.Changed) > 0 {
+		t.Errorf("residential-for-sale has differences %+v", d)
+	}
+
+	d := categories["residential-to-rent"]
+	if len(d.Added) > 0 || len(d.Removed) > 0 {
+		t.Errorf("added %v, removed %v, want none", refIds(d.Added), refIds(d.Removed))
+	}
+	if len(d.Changed) != 1 || !reflect.DeepEqual(changedFields(d.Changed[0]), []string{"ad__price"}) {
+		t.Errorf("changed %+v, want the price of advert 7 only", d.Changed)
+	}
+}
+
+func TestCompareSourcesInvalidAdvert(t *testing.T) {
+	// the invalid advert is the first advert of the feed, at line 2
+	invalidFeed := func(invalidId string, valid Advert) string {
+		return "<rubrikk>\n" +
+			"<ad><ad__number_reference_id>" + invalidId + "</ad__number_reference_id><ad__price>cheap</ad__price></ad>\n" +
+			strings.TrimPrefix(writeFeed(t, valid), "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<rubrikk>\n")
+	}
+
+	// an advert invalid in the new feed is reported as invalid, not as removed
+	categories := compareFeeds(t,
+		map[string]string{"feed1.xml": writeFeed(t, advert(8, "h", "100"), advert(9, "i", "100"))},
+		map[string]string{"feed1.xml": invalidFeed("9", advert(8, "h", "100"))},
+	)
+	d := categories["residential-for-sale"]
+	if len(d.Added) > 0 || len(d.Removed) > 0 || len(d.Changed) > 0 {
+		t.Errorf("added %v, removed %v, changed %+v, want none", refIds(d.Added), refIds(d.Removed), d.Changed)
+	}
+	if len(d.Invalid) != 1 || d.Invalid[0].Generation != "new" || d.Invalid[0].Id != 9 || d.Invalid[0].Line != 2 {
+		t.Errorf("invalid %+v, want advert 9 of the new feed at line 2", d.Invalid)
+	}
+
+	// an advert invalid in the old feed is not added
+	categories = compareFeeds(t,
+		map[string]string{"feed1.xml": invalidFeed("10", advert(8, "h", "100"))},
+		map[string]string{"feed1.xml": writeFeed(t, advert(8, "h", "100"), advert(10, "j", "100"))},
+	)
+	d = categories["residential-for-sale"]
+	if len(d.Added) > 0 || len(d.Removed) > 0 {
+		t.Errorf("added %v, removed %v, want none", refIds(d.Added), refIds(d.Removed))
+	}
+	if len(d.Invalid) != 1 || d.Invalid[0].Generation != "old" || d.Invalid[0].Id != 10 {
+		t.Errorf("invalid %+v, want advert 10 of the old feed", d.Invalid)
+	}
+}
+
+func TestDiffJSON(t *testing.T) {
+	diff, err := CompareSources(
+		writeSource(t, map[string]string{"feed1.xml": writeFeed(t, advert(1, "a", "100"), advert(2, "b", "100"))}),
+		writeSource(t, map[string]string{"feed1.xml": writeFeed(t, advert(1, "a", "150"), advert(3, "c", "100"))}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Old, diff.New = "old", "new"
+
+	output, err := json.Marshal(diff)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"old":"old","new":"new","categories":[{"category":"residential-for-sale","old_adverts":2,"new_adverts":2,` +
+		`"added":[{"id":3,"headline":"c","url":"https://example.com/property/c"}],` +
+		`"removed":[{"id":2,"headline":"b","url":"https://example.com/property/b"}],` +
+		`"changed":[{"id":1,"headline":"a","url":"https://example.com/property/a","fields":[{"field":"ad__price","old":"100","new":"150"}]}],` +
+		`"invalid":[]}]}`
+	if string(output) != want {
+		t.Errorf("got\n%s\nwant\n%s", output, want)
+	}
+}
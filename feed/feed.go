@@ -0,0 +1,46 @@
+package feed
+
+import (
+	"encoding/xml"
+
+	"github.com/shopspring/decimal"
+)
+
+// Rubrikk is a complete feed, the parser writes the adverts one at a time with FeedWriter
+// and the URL checker reads them one at a time with Reader
+type Rubrikk struct {
+	XMLName xml.Name `xml:"rubrikk"`
+	Adverts []Advert `xml:"ad"`
+}
+
+// Advert is an <ad> element of the feeds, written by the parser and read back by the URL
+// checker and the diff command
+type Advert struct {
+	XMLName              xml.Name        `xml:"ad"`
+	Id                   int             `xml:"ad__number_reference_id"`
+	AdHeadline           string          `xml:"ad__headline"`
+	Description          string          `xml:"ad__description"`
+	Price                decimal.Decimal `xml:"ad__price"`
+	PriceCurrency        string          `xml:"ad__price_currency"`
+	CompanyURL           string          `xml:"advertiser__company_homepage_url"`
+	Mobile               string          `xml:"advertiser__mobile"`
+	Phone                string          `xml:"advertiser__phone"`
+	URL                  string          `xml:"ad__url"`
+	Thumbnail            string          `xml:"ad__imageurl"`
+	AdvertImages         []string        `xml:"ad__all_imageurls>image"`
+	MainCategoryOriginal string          `xml:"maincategory_original"`
+	CategoryOriginal     string          `xml:"category_original"`
+	MunicipalityCity     string          `xml:"location__municipality_city"`
+	PostalName           string          `xml:"location__postal_name"`
+	Postcode             string          `xml:"location__zip_postal_code"`
+	Lat                  float32         `xml:"location__latitude"`
+	Lng                  float32         `xml:"location__longitude"`
+	StreetAddress        string          `xml:"location__streetaddress"`
+	Bed                  int32           `xml:"real_estate__beds,omitempty"`
+	Bathroom             int32           `xml:"real_estate__number_of_bathrooms,omitempty"`
+}
+
+// Marshal encodes the advert as it is written to the feeds
+func (advert Advert) Marshal() ([]byte, error) {
+	return xml.MarshalIndent(advert, "   ", "    ")
+}
@@ -0,0 +1,232 @@
+package feed
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func writeFeed(t *testing.T, adverts ...Advert) string {
+	t.Helper()
+
+	var feed strings.Builder
+	feed.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<rubrikk>\n")
+	for _, advert := range adverts {
+		output, err := advert.Marshal()
+		if err != nil {
+			t.Fatalf("marshal advert %d: %v", advert.Id, err)
+		}
+		feed.Write(output)
+		feed.WriteString("\n")
+	}
+	feed.WriteString("</rubrikk>\n")
+	return feed.String()
+}
+
+func readFeed(t *testing.T, feed string) []Advert {
+	t.Helper()
+
+	var adverts []Advert
+	reader := NewReader("feed1.xml", strings.NewReader(feed))
+	for {
+		advert, err := reader.Next()
+		if err == io.EOF {
+			return adverts
+		}
+		if err != nil {
+			t.Fatalf("read advert: %v", err)
+		}
+		adverts = append(adverts, advert)
+	}
+}
+
+// assertAdvert compares every field but XMLName, the prices with decimal.Equal
+func assertAdvert(t *testing.T, got Advert, want Advert) {
+	t.Helper()
+
+	if !got.Price.Equal(want.Price) {
+		t.Errorf("advert %d: price %s, want %s", want.Id, got.Price, want.Price)
+	}
+
+	got.XMLName = want.XMLName
+	got.Price, want.Price = decimal.Zero, decimal.Zero
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("advert %d:\n got %+v\nwant %+v", want.Id, got, want)
+	}
+}
+
+func sampleAdvert() Advert {
+	return Advert{
+		Id:                   42,
+		AdHeadline:           "2 bed flat for sale",
+		Description:          "Close to the station",
+		Price:                decimal.RequireFromString("1250.50"),
+		PriceCurrency:        "GBP",
+		CompanyURL:           "https://example.com/branch",
+		Mobile:               "07700 900000",
+		Phone:                "020 7946 0000",
+		URL:                  "https://example.com/property/42",
+		Thumbnail:            "https://example.com/images/42-thumb.jpg",
+		AdvertImages:         []string{"https://example.com/images/42-1.jpg", "https://example.com/images/42-2.jpg"},
+		MainCategoryOriginal: "Residential",
+		CategoryOriginal:     "For sale",
+		MunicipalityCity:     "London",
+		PostalName:           "Camden",
+		Postcode:             "NW1 0AA",
+		Lat:                  51.5390,
+		Lng:                  -0.1426,
+		StreetAddress:        "1 High Street",
+		Bed:                  2,
+		Bathroom:             1,
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	minimal := Advert{Id: 7, URL: "https://example.com/property/7", Price: decimal.RequireFromString("95000")}
+	want := []Advert{sampleAdvert(), minimal}
+
+	got := readFeed(t, writeFeed(t, want...))
+	if len(got) != len(want) {
+		t.Fatalf("read %d adverts, want %d", len(got), len(want))
+	}
+	for i := range want {
+		assertAdvert(t, got[i], want[i])
+	}
+}
+
+func TestRoundTripEscaping(t *testing.T) {
+	advert := sampleAdvert()
+	advert.AdHeadline = "Flat <new> & garden"
+	advert.Description = "Price < 300k & \"no chain\""
+	advert.URL = "https://example.com/property?id=42&ref=feed"
+	advert.AdvertImages = []string{"https://example.com/image?id=1&size=<large>"}
+
+	feed := writeFeed(t, advert)
+	if strings.Contains(feed, "<new>") || strings.Contains(feed, "id=42&ref") {
+		t.Fatalf("feed is not escaped:\n%s", feed)
+	}
+
+	got := readFeed(t, feed)
+	if len(got) != 1 {
+		t.Fatalf("read %d adverts, want 1", len(got))
+	}
+	assertAdvert(t, got[0], advert)
+}
+
+func TestRoundTripImages(t *testing.T) {
+	noImages := sampleAdvert()
+	noImages.Id = 1
+	noImages.AdvertImages = nil
+
+	manyImages := sampleAdvert()
+	manyImages.Id = 2
+	for i := 0; i < 20; i++ {
+		manyImages.AdvertImages = append(manyImages.AdvertImages, "https://example.com/images/2-"+string(rune('a'+i))+".jpg")
+	}
+
+	got := readFeed(t, writeFeed(t, noImages, manyImages))
+	if len(got) != 2 {
+		t.Fatalf("read %d adverts, want 2", len(got))
+	}
+	assertAdvert(t, got[0], noImages)
+	assertAdvert(t, got[1], manyImages)
+}
+
+func TestReaderPosition(t *testing.T) {
+	feed := "<rubrikk>\n" +
+		"<ad><ad__number_reference_id>1</ad__number_reference_id></ad>\n" +
+		"\n" +
+		"  <ad><ad__number_reference_id>2</ad__number_reference_id></ad>\n" +
+		"</rubrikk>\n"
+
+	reader := NewReader("feed1.xml", strings.NewReader(feed))
+	want := []Position{
+		{Line: 2, Column: 1, Offset: 10},
+		{Line: 4, Column: 3, Offset: 75},
+	}
+	for i, position := range want {
+		advert, err := reader.Next()
+		if err != nil {
+			t.Fatalf("advert %d: %v", i+1, err)
+		}
+		if advert.Id != i+1 {
+			t.Errorf("advert id %d, want %d", advert.Id, i+1)
+		}
+		if reader.Position() != position {
+			t.Errorf("advert %d: position %+v, want %+v", advert.Id, reader.Position(), position)
+		}
+	}
+
+	if _, err := reader.Next(); err != io.EOF {
+		t.Errorf("got %v, want io.EOF", err)
+	}
+}
+
+func TestReaderInvalidAdvert(t *testing.T) {
+	feed := "<rubrikk>\n" +
+		"<ad><ad__number_reference_id>1</ad__number_reference_id></ad>\n" +
+		"<ad><ad__number_reference_id>2</ad__number_reference_id><ad__price>cheap</ad__price></ad>\n" +
+		"<ad><ad__number_reference_id>3</ad__number_reference_id></ad>\n" +
+		"</rubrikk>\n"
+
+	reader := NewReader("feed1.xml", strings.NewReader(feed))
+
+	if advert, err := reader.Next(); err != nil || advert.Id != 1 {
+		t.Fatalf("got advert %d, %v, want advert 1", advert.Id, err)
+	}
+
+	_, err := reader.Next()
+	var feedErr *Error
+	if !errors.As(err, &feedErr) {
+		t.Fatalf("got %v, want *Error", err)
+	}
+	if feedErr.Malformed {
+		t.Errorf("invalid value is reported as a malformed feed: %v", feedErr)
+	}
+	if feedErr.Feed != "feed1.xml" || feedErr.Position.Line != 3 {
+		t.Errorf("error at %s line %d, want feed1.xml line 3", feedErr.Feed, feedErr.Position.Line)
+	}
+
+	// the reader goes on after an invalid advert
+	if advert, err := reader.Next(); err != nil || advert.Id != 3 {
+		t.Fatalf("got advert %d, %v, want advert 3", advert.Id, err)
+	}
+	if _, err := reader.Next(); err != io.EOF {
+		t.Errorf("got %v, want io.EOF", err)
+	}
+}
+
+func TestReaderMalformedFeed(t *testing.T) {
+	feed := "<rubrikk>\n" +
+		"<ad><ad__number_reference_id>1</ad__number_reference_id></ad>\n" +
+		"<ad><ad__number_reference_id>2</ad__number_reference_id></ad__headline></ad>\n" +
+		"<ad><ad__number_reference_id>3</ad__number_reference_id></ad>\n" +
+		"</rubrikk>\n"
+
+	reader := NewReader("feed1.xml", strings.NewReader(feed))
+
+	if advert, err := reader.Next(); err != nil || advert.Id != 1 {
+		t.Fatalf("got advert %d, %v, want advert 1", advert.Id, err)
+	}
+
+	_, err := reader.Next()
+	var feedErr *Error
+	if !errors.As(err, &feedErr) {
+		t.Fatalf("got %v, want *Error", err)
+	}
+	if !feedErr.Malformed {
+		t.Errorf("malformed feed is reported as an invalid advert: %v", feedErr)
+	}
+	if feedErr.Position.Line != 3 {
+		t.Errorf("error at line %d, want line 3", feedErr.Position.Line)
+	}
+
+	// the reader stops at a malformed feed
+	if _, err := reader.Next(); err != io.EOF {
+		t.Errorf("got %v, want io.EOF", err)
+	}
+}
@@ -0,0 +1,145 @@
+package feed
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Position of an advert or an error in a feed, the line and column start at 1
+type Position struct {
+	Line   int
+	Column int
+	Offset int64
+}
+
+// Error is a malformed feed or an advert which can not be decoded, the reader stops at
+// a malformed feed and goes on after an advert with an invalid value
+type Error struct {
+	Feed      string
+	Position  Position
+	Err       error
+	Malformed bool
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s:%d:%d (offset %d) - %s", e.Feed, e.Position.Line, e.Position.Column, e.Position.Offset, e.Err.Error())
+}
+
+// Reader decodes the <ad> elements of a feed one at a time while the feed is read, so a
+// feed of any size is checked in constant memory
+type Reader struct {
+	name     string
+	decoder  *xml.Decoder
+	lines    *lineCounter
+	position Position
+	failed   bool
+}
+
+func NewReader(name string, r io.Reader) *Reader {
+	lines := &lineCounter{reader: r, line: 1, column: 1}
+	return &Reader{name: name, decoder: xml.NewDecoder(lines), lines: lines}
+}
+
+// Position of the last advert returned by Next
+func (r *Reader) Position() Position {
+	return r.position
+}
+
+// Next returns the next advert, io.EOF at the end of the feed or a *Error
+func (r *Reader) Next() (Advert, error) {
+	var advert Advert
+	if r.failed {
+		return advert, io.EOF
+	}
+
+	for {
+		// the position is taken on every token, so the pending bytes never grow
+		position := r.lines.position(r.decoder.InputOffset())
+		token, err := r.decoder.Token()
+		if err == io.EOF {
+			return advert, io.EOF
+		}
+		if err != nil {
+			r.failed = true
+			return advert, r.malformed(err)
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "ad" {
+			continue
+		}
+
+		r.position = position
+		err = r.decoder.DecodeElement(&advert, &start)
+		if err == nil {
+			return advert, nil
+		}
+
+		var syntaxErr *xml.SyntaxError
+		if errors.As(err, &syntaxErr) || err == io.EOF || err == io.ErrUnexpectedEOF {
+			r.failed = true
+			return advert, r.malformed(err)
+		}
+
+		// an invalid value, e.g. a price which is not a number, the rest of the advert is
+		// skipped and the next advert is decoded
+		feedErr := &Error{Feed: r.name, Position: r.position, Err: err}
+		if skipErr := r.decoder.Skip(); skipErr != nil {
+			r.failed = true
+			return advert, r.malformed(skipErr)
+		}
+		return advert, feedErr
+	}
+}
+
+func (r *Reader) malformed(err error) *Error {
+	position := r.lines.position(r.decoder.InputOffset())
+
+	// the decoder counts the line of a syntax error itself
+	var syntaxErr *xml.SyntaxError
+	if errors.As(err, &syntaxErr) && syntaxErr.Line != position.Line {
+		position.Line = syntaxErr.Line
+		position.Column = 0
+	}
+	return &Error{Feed: r.name, Position: position, Err: err, Malformed: true}
+}
+
+// lineCounter keeps the bytes read by the decoder ahead of the last position, the positions
+// are requested in order so only the read ahead buffer of the decoder is kept
+type lineCounter struct {
+	reader  io.Reader
+	pending []byte
+	base    int64
+	line    int
+	column  int
+}
+
+func (c *lineCounter) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.pending = append(c.pending, p[:n]...)
+	return n, err
+}
+
+func (c *lineCounter) position(offset int64) Position {
+	consumed := offset - c.base
+	if consumed > int64(len(c.pending)) {
+		consumed = int64(len(c.pending))
+	}
+
+	if consumed > 0 {
+		for _, b := range c.pending[:consumed] {
+			if b == '\n' {
+				c.line++
+				c.column = 1
+			} else {
+				c.column++
+			}
+		}
+		c.pending = append(c.pending[:0], c.pending[consumed:]...)
+		c.base += consumed
+	}
+
+	return Position{Line: c.line, Column: c.column, Offset: offset}
+}
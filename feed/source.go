@@ -0,0 +1,205 @@
+package feed
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"bitbucket.org/waseka/waseka-xml-generator/utils"
+)
+
+// File is a feed of a source, a file of a feeds directory or a feed listed in a feed index
+type File struct {
+	Name     string
+	Category string
+	// file path or URL of the feed
+	Location string
+}
+
+// Client downloads the feed indexes and the feeds, it is created on first use since .env
+// file is loaded after the packages, see newClient
+var Client *http.Client
+var clientOnce sync.Once
+
+// newClient gives up a server which does not respond within "FEED_RESPONSE_TIMEOUT" of .env
+// file (default 30s). The download has no overall timeout, the body is read only as fast
+// as the adverts are checked, see idleBody for a server stalling in the middle of a feed
+func newClient() *http.Client {
+	responseTimeout := utils.EnvDuration("FEED_RESPONSE_TIMEOUT", 30*time.Second)
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: responseTimeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = responseTimeout
+	transport.ResponseHeaderTimeout = responseTimeout
+
+	return &http.Client{Transport: transport}
+}
+
+// idleBody cancels the download when a single read of the body waits longer than the
+// timeout for the server, the time the caller takes between the reads is not counted
+type idleBody struct {
+	body    io.ReadCloser
+	cancel  context.CancelFunc
+	timeout time.Duration
+}
+
+func (b *idleBody) Read(p []byte) (int, error) {
+	timer := time.AfterFunc(b.timeout, b.cancel)
+	n, err := b.body.Read(p)
+	if !timer.Stop() && err != nil {
+		return n, fmt.Errorf("download stalled for more than %s - %s", b.timeout, err.Error())
+	}
+	return n, err
+}
+
+func (b *idleBody) Close() error {
+	err := b.body.Close()
+	b.cancel()
+	return err
+}
+
+func isURL(location string) bool {
+	return strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://")
+}
+
+// Files resolves the feeds of the source, a feed index URL, e.g. the published
+// https://example.com/feed.xml, a feed index file, an export path or a feeds directory
+func Files(source string) ([]File, error) {
+	if isURL(source) {
+		return indexFiles(source)
+	}
+
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return indexFiles(source)
+	}
+
+	// an export path keeps its feeds in the feeds directory next to feed.xml
+	if info, err := os.Stat(filepath.Join(source, "feeds")); err == nil && info.IsDir() {
+		return directoryFiles(filepath.Join(source, "feeds"))
+	}
+	return directoryFiles(source)
+}
+
+func directoryFiles(dirName string) ([]File, error) {
+	files, err := ioutil.ReadDir(dirName)
+	if err != nil {
+		return nil, err
+	}
+
+	var feeds []File
+	for _, file := range files {
+		// compressed variants of the feeds are skipped, they hold the same adverts
+		if filepath.Ext(file.Name()) != ".xml" {
+			continue
+		}
+		feeds = append(feeds, File{
+			Name:     file.Name(),
+			Category: utils.CategoryByFileName(file.Name()),
+			Location: filepath.Join(dirName, file.Name()),
+		})
+	}
+
+	return feeds, nil
+}
+
+// indexFiles reads the <loc> entries of a feed index written by CreatePublicXmlFile of utils
+func indexFiles(location string) ([]File, error) {
+	body, err := Open(location)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	type FeedLocation struct {
+		URL      string `xml:",chardata"`
+		Category string `xml:"category,attr"`
+	}
+
+	var feeds []File
+	decoder := xml.NewDecoder(body)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid feed index %s - %s", location, err.Error())
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "loc" {
+			continue
+		}
+
+		var loc FeedLocation
+		if err = decoder.DecodeElement(&loc, &start); err != nil {
+			return nil, fmt.Errorf("invalid feed index %s - %s", location, err.Error())
+		}
+
+		feedURL := strings.TrimSpace(loc.URL)
+		name := path.Base(feedURL)
+		if u, err := url.Parse(feedURL); err == nil {
+			name = path.Base(u.Path)
+		}
+
+		// compressed variants of the feeds are skipped, they hold the same adverts
+		if path.Ext(name) != ".xml" {
+			continue
+		}
+
+		category := loc.Category
+		if category == "" {
+			category = utils.CategoryByFileName(name)
+		}
+		feeds = append(feeds, File{Name: name, Category: category, Location: feedURL})
+	}
+
+	return feeds, nil
+}
+
+// Open opens a file or downloads a URL, the body is read while it is decoded
+func Open(location string) (io.ReadCloser, error) {
+	if !isURL(location) {
+		return os.Open(location)
+	}
+
+	clientOnce.Do(func() {
+		if Client == nil {
+			Client = newClient()
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	res, err := Client.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("download of %s failed with status %d", location, res.StatusCode)
+	}
+	return &idleBody{body: res.Body, cancel: cancel, timeout: utils.EnvDuration("FEED_RESPONSE_TIMEOUT", 30*time.Second)}, nil
+}
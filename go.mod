@@ -3,7 +3,10 @@ module bitbucket.org/waseka/waseka-xml-generator
 go 1.16
 
 require (
-	github.com/go-sql-driver/mysql v1.6.0 // indirect
-	github.com/joho/godotenv v1.4.0 // indirect
-	github.com/shopspring/decimal v1.3.1 // indirect
+	github.com/go-sql-driver/mysql v1.6.0
+	github.com/joho/godotenv v1.4.0
+	github.com/klauspost/compress v1.15.9
+	github.com/pkg/sftp v1.13.5
+	github.com/shopspring/decimal v1.3.1
+	golang.org/x/crypto v0.0.0-20211215153901-e495a2d5b3d3
 )
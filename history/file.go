@@ -0,0 +1,63 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+)
+
+// FileStore keeps one JSON encoded run per line
+type FileStore struct {
+	FilePath string
+}
+
+func (s *FileStore) Save(run Run) error {
+	output, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(s.FilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err = f.Write(append(output, "\n"...)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (s *FileStore) Latest(category string, limit int) ([]Run, error) {
+	f, err := os.Open(s.FilePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var runs []Run
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var run Run
+		if err := json.Unmarshal(scanner.Bytes(), &run); err != nil || run.Category != category {
+			continue
+		}
+
+		// newest first
+		runs = append([]Run{run}, runs...)
+		if len(runs) > limit {
+			runs = runs[:limit]
+		}
+	}
+
+	return runs, scanner.Err()
+}
+
+func (s *FileStore) Close() error {
+	return nil
+}
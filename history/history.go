@@ -0,0 +1,62 @@
+package history
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Run of a single category, one generation of the feeds records a run per category
+type Run struct {
+	Id           string    `json:"id"`
+	Category     string    `json:"category"`
+	StartedAt    time.Time `json:"started_at"`
+	FinishedAt   time.Time `json:"finished_at"`
+	Parsed       int       `json:"parsed"`
+	Skipped      int       `json:"skipped"`
+	Failed       int       `json:"failed"`
+	OutputFiles  []string  `json:"output_files"`
+	ExportResult string    `json:"export_result"`
+	Error        string    `json:"error,omitempty"`
+}
+
+type Store interface {
+	Save(run Run) error
+	// Latest returns the last runs of the category, the newest first
+	Latest(category string, limit int) ([]Run, error)
+	Close() error
+}
+
+// NewStore opens the store of "RUN_HISTORY_STORE" of .env file, "file" (default) appends
+// to "RUN_HISTORY_FILE" and "mysql" inserts into the xml_generator_runs table
+func NewStore() (Store, error) {
+	if os.Getenv("RUN_HISTORY_STORE") == "mysql" {
+		return newMySQLStore()
+	}
+
+	filePath := os.Getenv("RUN_HISTORY_FILE")
+	if filePath == "" {
+		filePath = "run-history.jsonl"
+	}
+	return &FileStore{FilePath: filePath}, nil
+}
+
+// NewRunId identifies the runs of the categories generated together
+func NewRunId() string {
+	return time.Now().Format("20060102150405") + "-" + strconv.Itoa(os.Getpid())
+}
+
+// Record saves the run, a run which can not be recorded must not fail the generation
+// so the error is only printed
+func Record(run Run) {
+	store, err := NewStore()
+	if err == nil {
+		err = store.Save(run)
+		store.Close()
+	}
+
+	if err != nil {
+		fmt.Println("Unable to record run history - " + err.Error())
+	}
+}
@@ -0,0 +1,96 @@
+package history
+
+import (
+	"database/sql"
+	"os"
+	"strings"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+const createTableQuery = `CREATE TABLE IF NOT EXISTS xml_generator_runs (
+	id bigint unsigned NOT NULL AUTO_INCREMENT PRIMARY KEY,
+	run_id varchar(64) NOT NULL,
+	category varchar(64) NOT NULL,
+	started_at datetime NOT NULL,
+	finished_at datetime NOT NULL,
+	parsed int NOT NULL DEFAULT 0,
+	skipped int NOT NULL DEFAULT 0,
+	failed int NOT NULL DEFAULT 0,
+	output_files text,
+	export_result text,
+	error text,
+	KEY category_started_at (category, started_at)
+)`
+
+type MySQLStore struct {
+	db *sql.DB
+}
+
+func newMySQLStore() (*MySQLStore, error) {
+	db, err := sql.Open("mysql", os.Getenv("MYSQL_USER")+":"+os.Getenv("MYSQL_PASSWORD")+"@tcp("+os.Getenv("MYSQL_HOST")+":"+os.Getenv("MYSQL_PORT")+")/"+os.Getenv("MYSQL_DATABASE"))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = db.Exec(createTableQuery); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &MySQLStore{db: db}, nil
+}
+
+func (s *MySQLStore) Save(run Run) error {
+	_, err := s.db.Exec(
+		"INSERT INTO xml_generator_runs (run_id, category, started_at, finished_at, parsed, skipped, failed, output_files, export_result, error) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		run.Id,
+		run.Category,
+		run.StartedAt.Format("2006-01-02 15:04:05"),
+		run.FinishedAt.Format("2006-01-02 15:04:05"),
+		run.Parsed,
+		run.Skipped,
+		run.Failed,
+		strings.Join(run.OutputFiles, ","),
+		run.ExportResult,
+		run.Error,
+	)
+	return err
+}
+
+func (s *MySQLStore) Latest(category string, limit int) ([]Run, error) {
+	rows, err := s.db.Query("SELECT run_id, category, started_at, finished_at, parsed, skipped, failed, output_files, export_result, error FROM xml_generator_runs WHERE category = ? ORDER BY started_at DESC, id DESC LIMIT ?", category, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []Run
+	for rows.Next() {
+		var run Run
+		var startedAt, finishedAt string
+		var outputFiles, exportResult, runError sql.NullString
+
+		err = rows.Scan(&run.Id, &run.Category, &startedAt, &finishedAt, &run.Parsed, &run.Skipped, &run.Failed, &outputFiles, &exportResult, &runError)
+		if err != nil {
+			return nil, err
+		}
+
+		run.StartedAt, _ = time.ParseInLocation("2006-01-02 15:04:05", startedAt, time.Local)
+		run.FinishedAt, _ = time.ParseInLocation("2006-01-02 15:04:05", finishedAt, time.Local)
+		if outputFiles.String != "" {
+			run.OutputFiles = strings.Split(outputFiles.String, ",")
+		}
+		run.ExportResult = exportResult.String
+		run.Error = runError.String
+
+		runs = append(runs, run)
+	}
+
+	return runs, rows.Err()
+}
+
+func (s *MySQLStore) Close() error {
+	return s.db.Close()
+}
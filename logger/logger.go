@@ -0,0 +1,212 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	DEBUG Level = iota
+	INFO
+	WARN
+	ERROR
+)
+
+var LevelMap = map[string]Level{
+	"debug": DEBUG,
+	"info":  INFO,
+	"warn":  WARN,
+	"error": ERROR,
+}
+
+func (level Level) String() string {
+	for name, l := range LevelMap {
+		if l == level {
+			return name
+		}
+	}
+	return strconv.Itoa(int(level))
+}
+
+// Logger writes entries with its fields, e.g. the category of the parser, to the sinks of
+// "LOG_SINKS" of .env file
+type Logger struct {
+	fields []interface{}
+}
+
+var config struct {
+	once   sync.Once
+	level  Level
+	format string
+	sinks  []*sink
+	runId  string
+	mut    sync.Mutex
+}
+
+// configure reads the .env configuration on the first entry, after main loaded .env file.
+// "LOG_LEVEL" is debug, info (default), warn or error and "LOG_FORMAT" is logfmt (default)
+// or json
+func configure() {
+	config.once.Do(func() {
+		level, ok := LevelMap[strings.ToLower(os.Getenv("LOG_LEVEL"))]
+		if !ok {
+			level = INFO
+		}
+		config.level = level
+
+		config.format = strings.ToLower(os.Getenv("LOG_FORMAT"))
+		if config.format != "json" {
+			config.format = "logfmt"
+		}
+
+		config.sinks = openSinks()
+	})
+}
+
+// SetRunId adds the run id to every following entry, e.g. the run id of the run history
+func SetRunId(runId string) {
+	config.mut.Lock()
+	defer config.mut.Unlock()
+	config.runId = runId
+}
+
+// With returns a logger adding the key value pairs to every entry
+func With(keyvals ...interface{}) *Logger {
+	return &Logger{fields: keyvals}
+}
+
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	fields := append(append([]interface{}{}, l.fields...), keyvals...)
+	return &Logger{fields: fields}
+}
+
+func (l *Logger) Debug(msg string, keyvals ...interface{}) {
+	l.log(DEBUG, msg, keyvals)
+}
+
+func (l *Logger) Info(msg string, keyvals ...interface{}) {
+	l.log(INFO, msg, keyvals)
+}
+
+func (l *Logger) Warn(msg string, keyvals ...interface{}) {
+	l.log(WARN, msg, keyvals)
+}
+
+func (l *Logger) Error(msg string, keyvals ...interface{}) {
+	l.log(ERROR, msg, keyvals)
+}
+
+// Fatal writes an error entry and exits with code 1
+func (l *Logger) Fatal(msg string, keyvals ...interface{}) {
+	l.log(ERROR, msg, keyvals)
+	os.Exit(1)
+}
+
+var std = &Logger{}
+
+func Debug(msg string, keyvals ...interface{}) {
+	std.log(DEBUG, msg, keyvals)
+}
+
+func Info(msg string, keyvals ...interface{}) {
+	std.log(INFO, msg, keyvals)
+}
+
+func Warn(msg string, keyvals ...interface{}) {
+	std.log(WARN, msg, keyvals)
+}
+
+func Error(msg string, keyvals ...interface{}) {
+	std.log(ERROR, msg, keyvals)
+}
+
+func Fatal(msg string, keyvals ...interface{}) {
+	std.Fatal(msg, keyvals...)
+}
+
+type field struct {
+	key   string
+	value interface{}
+}
+
+func (l *Logger) log(level Level, msg string, keyvals []interface{}) {
+	configure()
+	if level < config.level {
+		return
+	}
+
+	config.mut.Lock()
+	defer config.mut.Unlock()
+
+	fields := []field{
+		{"time", time.Now().Local().Format(time.RFC3339)},
+		{"level", level.String()},
+		{"msg", msg},
+	}
+	if config.runId != "" {
+		fields = append(fields, field{"run_id", config.runId})
+	}
+
+	all := append(append([]interface{}{}, l.fields...), keyvals...)
+	for i := 0; i < len(all); i += 2 {
+		key := fmt.Sprint(all[i])
+		var value interface{} = "(missing)"
+		if i+1 < len(all) {
+			value = all[i+1]
+		}
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+		fields = append(fields, field{key, value})
+	}
+
+	var line string
+	if config.format == "json" {
+		line = formatJSON(fields)
+	} else {
+		line = formatLogfmt(fields)
+	}
+
+	for _, s := range config.sinks {
+		s.write(line)
+	}
+}
+
+func formatJSON(fields []field) string {
+	entry := map[string]interface{}{}
+	for _, f := range fields {
+		entry[f.key] = f.value
+	}
+
+	output, err := json.Marshal(entry)
+	if err != nil {
+		// values which can not be marshalled are written as their string
+		for key, value := range entry {
+			entry[key] = fmt.Sprint(value)
+		}
+		output, _ = json.Marshal(entry)
+	}
+	return string(output) + "\n"
+}
+
+func formatLogfmt(fields []field) string {
+	var pairs []string
+	for _, f := range fields {
+		pairs = append(pairs, f.key+"="+logfmtValue(fmt.Sprint(f.value)))
+	}
+	return strings.Join(pairs, " ") + "\n"
+}
+
+func logfmtValue(value string) string {
+	if value == "" || strings.ContainsAny(value, " =\"\t\n\r") {
+		return strconv.Quote(value)
+	}
+	return value
+}
@@ -0,0 +1,118 @@
+package logger
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const DEFAULT_SINKS = "stdout,log.txt"
+
+// sink is stdout, stderr or a log file which is rotated once it grows over "LOG_MAX_SIZE"
+type sink struct {
+	writer   io.Writer
+	file     *os.File
+	filePath string
+	size     int64
+	maxSize  int64
+	maxFiles int
+}
+
+// openSinks opens the sinks of "LOG_SINKS" of .env file, a comma separated list of stdout,
+// stderr and file paths, defaults to stdout and log.txt. "LOG_MAX_SIZE" in MB (default 10)
+// and "LOG_MAX_FILES" (default 5) configure the rotation of the log files
+func openSinks() []*sink {
+	value := os.Getenv("LOG_SINKS")
+	if value == "" {
+		value = DEFAULT_SINKS
+	}
+
+	maxSize := envInt("LOG_MAX_SIZE", 10)
+	maxFiles := envInt("LOG_MAX_FILES", 5)
+
+	var sinks []*sink
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		switch name {
+		case "":
+			continue
+		case "stdout":
+			sinks = append(sinks, &sink{writer: os.Stdout})
+		case "stderr":
+			sinks = append(sinks, &sink{writer: os.Stderr})
+		default:
+			s := &sink{filePath: name, maxSize: int64(maxSize) * 1024 * 1024, maxFiles: maxFiles}
+			if err := s.open(); err != nil {
+				fmt.Fprintln(os.Stderr, "Unable to open log file - "+err.Error())
+				continue
+			}
+			sinks = append(sinks, s)
+		}
+	}
+
+	return sinks
+}
+
+func envInt(key string, fallback int) int {
+	number, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || number <= 0 {
+		return fallback
+	}
+	return number
+}
+
+func (s *sink) open() error {
+	f, err := os.OpenFile(s.filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	s.file = f
+	s.writer = f
+	s.size = info.Size()
+	return nil
+}
+
+func (s *sink) write(line string) {
+	if s.file != nil && s.size+int64(len(line)) > s.maxSize && s.size > 0 {
+		s.rotate()
+	}
+	if s.writer == nil {
+		return
+	}
+
+	n, err := io.WriteString(s.writer, line)
+	s.size += int64(n)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to write log - "+err.Error())
+	}
+}
+
+// rotate renames log.txt to log.txt.1, log.txt.1 to log.txt.2 and so on, the oldest file
+// over "LOG_MAX_FILES" is removed
+func (s *sink) rotate() {
+	s.file.Close()
+	s.file = nil
+	s.writer = nil
+
+	os.Remove(s.filePath + "." + strconv.Itoa(s.maxFiles))
+	for i := s.maxFiles - 1; i >= 1; i-- {
+		os.Rename(s.filePath+"."+strconv.Itoa(i), s.filePath+"."+strconv.Itoa(i+1))
+	}
+
+	if err := os.Rename(s.filePath, s.filePath+".1"); err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to rotate log file - "+err.Error())
+	}
+
+	if err := s.open(); err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to open log file - "+err.Error())
+	}
+}
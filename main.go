@@ -1,14 +1,28 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"log"
 	"os"
+	"strings"
 	"time"
 
+	"bitbucket.org/waseka/waseka-xml-generator/exporter"
+	"bitbucket.org/waseka/waseka-xml-generator/history"
+	"bitbucket.org/waseka/waseka-xml-generator/logger"
+	"bitbucket.org/waseka/waseka-xml-generator/manifest"
+	"bitbucket.org/waseka/waseka-xml-generator/metrics"
 	"bitbucket.org/waseka/waseka-xml-generator/parser"
+	"bitbucket.org/waseka/waseka-xml-generator/runlock"
+	"bitbucket.org/waseka/waseka-xml-generator/server"
+	"bitbucket.org/waseka/waseka-xml-generator/sitemap"
+	"bitbucket.org/waseka/waseka-xml-generator/syndication"
 	"bitbucket.org/waseka/waseka-xml-generator/urlchecker"
 	"bitbucket.org/waseka/waseka-xml-generator/utils"
+
+	"github.com/joho/godotenv"
 )
 
 var start time.Time
@@ -18,10 +32,23 @@ func init() {
 }
 
 func main() {
-	fmt.Println("main execution started at time", time.Since(start))
-	executionTypePtr := flag.String("type", "parse", "Run program to parse or test")
+	// the timings go to stderr, so the output of e.g. --type=diff --format=json can be piped
+	fmt.Fprintln(os.Stderr, "main execution started at time", time.Since(start))
+	executionTypePtr := flag.String("type", "parse", "Run program to parse, test, rollback, verify, serve, daemon, status or diff")
+	releasePtr := flag.String("to", "", "Release to rollback the exported feeds to")
+	dirPtr := flag.String("dir", "", "Feed directory to verify against its manifest, defaults to EXPORT_PATH")
+	sourcePtr := flag.String("source", "", "Feed index URL, feed index file or export path to test, defaults to the feeds directory")
+	oldPtr := flag.String("old", "", "Feed index URL, feed index file or export path of the old feeds to diff")
+	newPtr := flag.String("new", "", "Feed index URL, feed index file or export path of the new feeds to diff, defaults to the feeds directory")
+	formatPtr := flag.String("format", "text", "Format of the diff, text or json")
+	outputPtr := flag.String("output", "", "File to write the diff to, defaults to stdout")
+	resumePtr := flag.Bool("resume", false, "Resume an interrupted test, URLs verified successfully within URL_CHECK_FRESHNESS are skipped")
+	limitPtr := flag.Int("limit", 5, "Number of runs per category shown by status")
 	flag.Parse()
 
+	// .env file is loaded again by the parser which requires it for MySQL
+	godotenv.Load()
+
 	executionType, err := utils.VerifyExecutionType(*executionTypePtr)
 
 	if err != nil {
@@ -31,29 +58,244 @@ func main() {
 	if executionType == "parse" {
 		xmlParser()
 	} else if executionType == "test" {
-		urlChecker()
+		urlChecker(*sourcePtr, *resumePtr)
+	} else if executionType == "rollback" {
+		rollback(*releasePtr)
+	} else if executionType == "verify" {
+		verify(*dirPtr)
+	} else if executionType == "serve" {
+		serve()
+	} else if executionType == "daemon" {
+		daemon()
+	} else if executionType == "status" {
+		status(*limitPtr)
+	} else if executionType == "diff" {
+		diff(*oldPtr, *newPtr, *formatPtr, *outputPtr)
+	}
+
+	fmt.Fprintln(os.Stderr, "\nmain execution stopped at time", time.Since(start))
+}
+
+// urlChecker checks the feeds of the source, "URL_CHECK_SOURCE" of .env file when no
+// --source is given, e.g. the published feed.xml to check what partners see. With --resume
+// the progress of the previous check is kept
+func urlChecker(source string, resume bool) {
+	if source == "" {
+		source = os.Getenv("URL_CHECK_SOURCE")
+	}
+
+	defer metrics.WriteTextfile()
+	logger.SetRunId(history.NewRunId())
+	urlchecker.CheckURL(source, resume)
+}
+
+// rollback points the local and s3 targets of "EXPORT_TARGETS" back to the release, it takes
+// the run lock so it never races an export
+func rollback(release string) {
+	targets := exporter.Targets()
+
+	if release == "" {
+		releasers, err := exporter.Releasers(targets)
+		if err != nil {
+			panic(err.Error())
+		}
+
+		for _, r := range releasers {
+			releases, err := r.Releases()
+			if err != nil {
+				panic(err.Error())
+			}
+
+			fmt.Println(r.Name()+" current release -", r.Current())
+			fmt.Println(r.Name()+" available releases -", strings.Join(releases, ", "))
+		}
+		log.Fatal("Release is required, e.g. --type=rollback --to=<release>")
+	}
+
+	lock := acquireRunLock()
+	defer lock.Release()
+
+	if err := exporter.Rollback(targets, release); err != nil {
+		panic(err.Error())
 	}
 
-	fmt.Println("\nmain execution stopped at time", time.Since(start))
+	fmt.Println("Exported feeds are rolled back to release", release)
 }
 
-func urlChecker() {
-	urlchecker.CheckURL()
+func verify(dir string) {
+	if dir == "" {
+		dir = os.Getenv("EXPORT_PATH")
+	}
+
+	problems, err := manifest.Verify(dir)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+
+	if len(problems) > 0 {
+		log.Fatal("Feeds of " + dir + " do not match the manifest")
+	}
+
+	fmt.Println("Feeds of " + dir + " match the manifest")
+}
+
+func serve() {
+	s, err := server.New()
+	if err != nil {
+		panic(err.Error())
+	}
+
+	// metrics are served on their own listener, nginx/default.conf proxies every path of
+	// the feed server to the public
+	go func() {
+		if err := metrics.ListenAndServe(); err != nil {
+			fmt.Println("Unable to serve metrics - " + err.Error())
+		}
+	}()
+
+	if err := s.ListenAndServe(); err != nil {
+		panic(err.Error())
+	}
+}
+
+// acquireRunLock exits with the exit code of runlock when another run is in progress
+func acquireRunLock() runlock.Lock {
+	lock, err := runlock.Acquire()
+	if errors.Is(err, runlock.ErrLocked) {
+		fmt.Println("Another run in progress, exiting")
+		os.Exit(runlock.EXIT_CODE)
+	}
+	if err != nil {
+		panic(err.Error())
+	}
+	return lock
 }
 
 func xmlParser() {
-	// remove existent contents from feeds directory of golang app
-	utils.RemoveExistentContents("feeds")
+	// only one run may work on the feeds directory at a time
+	lock := acquireRunLock()
+	defer lock.Release()
 
-	// parse each property category based on "PropertyTableMap" of utils
+	// one-shot runs leave their metrics to the textfile collector
+	defer metrics.WriteTextfile()
+
+	var categories []string
 	for category := range utils.PropertyTableMap {
-		parser.ParseToXML(category)
+		categories = append(categories, category)
+	}
+
+	// check "IS_EXPORTABLE" from .env file to determine to export feeds from golang app
+	// to every target of "EXPORT_TARGETS" of .env file
+	var targets []string
+	if os.Getenv("IS_EXPORTABLE") == "true" {
+		targets = exporter.Targets()
+	}
+
+	generate(categories, targets)
+}
+
+// generate parses the categories and exports them to the targets. A run of every category
+// is recorded to the run history, also when the generation fails
+func generate(categories []string, targets []string) {
+	runId := history.NewRunId()
+	logger.SetRunId(runId)
+	var runs []history.Run
+	for _, category := range categories {
+		runs = append(runs, history.Run{Id: runId, Category: category, ExportResult: "not exported"})
+	}
+
+	defer recordRuns(runs)
+
+	parseCategories(runs)
+
+	if len(targets) == 0 {
+		return
+	}
+
+	err := exporter.Export(targets)
+	for i := range runs {
+		runs[i].ExportResult = "exported to " + strings.Join(targets, ", ")
+		if err != nil {
+			runs[i].ExportResult = "export failed - " + err.Error()
+		}
+	}
+
+	if err != nil {
+		panic(err.Error())
+	}
+}
+
+// recordRuns saves the runs which started, the error of a failed generation is recorded
+// to the run in progress and raised again
+func recordRuns(runs []history.Run) {
+	r := recover()
+
+	for _, run := range runs {
+		if run.StartedAt.IsZero() {
+			continue
+		}
+
+		// the finish time of a parsed category is kept, a run in progress finishes now
+		if run.FinishedAt.IsZero() {
+			if r != nil {
+				run.Error = fmt.Sprint(r)
+			}
+			run.FinishedAt = time.Now()
+		}
+
+		history.Record(run)
+	}
+
+	if r != nil {
+		panic(r)
+	}
+}
+
+// parseCategories regenerates the feeds of the categories of the runs and keeps the feeds of
+// the others. Sitemaps and syndication feeds need the listings of every category, so they
+// are only generated when every category is parsed
+func parseCategories(runs []history.Run) {
+	isFullRun := len(runs) == len(utils.PropertyTableMap)
+	sitemap.Reset()
+	syndication.Reset()
+
+	if isFullRun {
+		// remove existent contents from feeds directory of golang app
+		utils.RemoveExistentContents("feeds")
+	} else {
+		for _, run := range runs {
+			utils.RemoveCategoryFeeds("feeds", run.Category)
+		}
+	}
+
+	// parse each property category
+	for i := range runs {
+		runs[i].StartedAt = time.Now()
+		summary := parser.ParseToXML(runs[i].Category)
+
+		runs[i].FinishedAt = time.Now()
+		runs[i].Parsed = summary.Parsed
+		runs[i].Skipped = summary.Skipped
+		runs[i].Failed = summary.Failed
+		runs[i].OutputFiles = summary.Files
+		runs[i].Error = summary.Error
+	}
+
+	if !isFullRun {
+		return
+	}
+
+	// check "GENERATE_SITEMAP" from .env file to write sitemaps of the parsed listings
+	if sitemap.IsEnabled() {
+		sitemap.Generate()
 	}
 
-	// check "IS_EXPORTABLE" from .env file to determine to transfer feeds from golang app
-	// to "EXPORT_PATH" directroy of .env file
-	isExportable := os.Getenv("IS_EXPORTABLE")
-	if isExportable == "true" {
-		utils.TransferFeeds()
+	// check "GENERATE_SYNDICATION" from .env file to write atom and rss feeds of the newest listings
+	if syndication.IsEnabled() {
+		syndication.Generate()
 	}
 }
@@ -0,0 +1,248 @@
+package manifest
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"bitbucket.org/waseka/waseka-xml-generator/logger"
+	"bitbucket.org/waseka/waseka-xml-generator/utils"
+)
+
+const FILE_NAME = "manifest.json"
+const SIGNATURE_FILE_NAME = "manifest.json.sig"
+
+type Manifest struct {
+	GeneratedAt string `json:"generated_at"`
+	// false when no signing key was set, so a missing signature of a signed manifest is
+	// told apart from a manifest unsigned by design
+	Signed bool   `json:"signed"`
+	Files  []File `json:"files"`
+}
+
+type File struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Entry is a file of a manifest, Path is the path of the file next to the manifest and
+// LocalPath the generated file
+type Entry struct {
+	Path      string
+	LocalPath string
+}
+
+// Create writes manifest.json of feed.xml and every file of the "ExportDirectories" of
+// utils and signs it to manifest.json.sig, see Write. The targets write feed.xml with
+// their own URLs, so every target creates the manifest after writing its feed.xml
+func Create() error {
+	var entries []Entry
+	if _, err := os.Stat("feed.xml"); err == nil {
+		entries = append(entries, Entry{Path: "feed.xml", LocalPath: "feed.xml"})
+	}
+
+	for _, dirName := range utils.ExportDirectories {
+		files, err := ioutil.ReadDir(dirName)
+		if err != nil {
+			// directories of disabled outputs are not generated
+			continue
+		}
+
+		for _, file := range files {
+			if file.IsDir() {
+				continue
+			}
+
+			filePath := dirName + "/" + file.Name()
+			entries = append(entries, Entry{Path: filePath, LocalPath: filePath})
+		}
+	}
+
+	return Write(FILE_NAME, SIGNATURE_FILE_NAME, entries)
+}
+
+// Write writes the manifest of the entries to filePath and signs it to signatureFilePath
+// with the Ed25519 key of "MANIFEST_SIGNING_KEY" or "MANIFEST_SIGNING_KEY_FILE" of .env
+// file. Without a key the manifest is written unsigned with a warning, or refused when
+// "MANIFEST_REQUIRE_SIGNATURE" is true
+func Write(filePath string, signatureFilePath string, entries []Entry) error {
+	privateKey, err := signingKey()
+	if err != nil {
+		return err
+	}
+
+	if privateKey == nil {
+		if os.Getenv("MANIFEST_REQUIRE_SIGNATURE") == "true" {
+			return fmt.Errorf("MANIFEST_SIGNING_KEY is not set in .env file and MANIFEST_REQUIRE_SIGNATURE is true")
+		}
+		logger.Warn("Manifest is not signed, MANIFEST_SIGNING_KEY is not set in .env file", "manifest", filePath)
+	}
+
+	manifest := Manifest{GeneratedAt: time.Now().Format(time.RFC3339), Signed: privateKey != nil}
+	for _, entry := range entries {
+		info, err := os.Stat(entry.LocalPath)
+		if err != nil {
+			return err
+		}
+
+		manifest.Files = append(manifest.Files, File{
+			Path:   entry.Path,
+			Size:   info.Size(),
+			SHA256: utils.FileChecksum(entry.LocalPath),
+		})
+	}
+
+	output, err := json.MarshalIndent(manifest, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	if err = ioutil.WriteFile(filePath, output, 0777); err != nil {
+		return err
+	}
+
+	os.Remove(signatureFilePath)
+	if privateKey == nil {
+		return nil
+	}
+
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, output))
+	return ioutil.WriteFile(signatureFilePath, []byte(signature+"\n"), 0777)
+}
+
+// Verify checks every file of the manifest of the directory and the signature of the manifest
+// with the public key of "MANIFEST_PUBLIC_KEY" or "MANIFEST_PUBLIC_KEY_FILE" of .env file.
+// It returns every problem found, an empty list means the directory is intact
+func Verify(dir string) ([]string, error) {
+	output, err := ioutil.ReadFile(filepath.Join(dir, FILE_NAME))
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest Manifest
+	if err = json.Unmarshal(output, &manifest); err != nil {
+		return nil, err
+	}
+
+	var problems []string
+
+	publicKey, err := publicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	if publicKey == nil {
+		fmt.Println("Signature is not checked, MANIFEST_PUBLIC_KEY is not set in .env file")
+		if !manifest.Signed {
+			fmt.Println("Manifest was written without MANIFEST_SIGNING_KEY")
+		}
+	} else if !manifest.Signed {
+		problems = append(problems, "manifest is not signed, it was written without MANIFEST_SIGNING_KEY")
+	} else {
+		signature, err := ioutil.ReadFile(filepath.Join(dir, SIGNATURE_FILE_NAME))
+		if err != nil {
+			problems = append(problems, "signature of the signed manifest is missing - "+err.Error())
+		} else if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature))); err != nil || !ed25519.Verify(publicKey, output, decoded) {
+			problems = append(problems, "signature does not match the manifest")
+		}
+	}
+
+	for _, file := range manifest.Files {
+		filePath := filepath.Join(dir, file.Path)
+		info, err := os.Stat(filePath)
+		if err != nil {
+			problems = append(problems, file.Path+" is missing")
+			continue
+		}
+
+		if info.Size() != file.Size {
+			problems = append(problems, fmt.Sprintf("%s size is %d, manifest has %d", file.Path, info.Size(), file.Size))
+			continue
+		}
+
+		if checksum := utils.FileChecksum(filePath); checksum != file.SHA256 {
+			problems = append(problems, fmt.Sprintf("%s sha256 is %s, manifest has %s", file.Path, checksum, file.SHA256))
+		}
+	}
+
+	return problems, nil
+}
+
+func signingKey() (ed25519.PrivateKey, error) {
+	key, err := readKey("MANIFEST_SIGNING_KEY")
+	if key == nil || err != nil {
+		return nil, err
+	}
+
+	switch len(key) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(key), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(key), nil
+	}
+
+	// PKCS #8 key, e.g. from "openssl genpkey -algorithm ed25519"
+	parsed, err := x509.ParsePKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("MANIFEST_SIGNING_KEY is not an Ed25519 key")
+	}
+	return privateKey, nil
+}
+
+func publicKey() (ed25519.PublicKey, error) {
+	key, err := readKey("MANIFEST_PUBLIC_KEY")
+	if key == nil || err != nil {
+		return nil, err
+	}
+
+	if len(key) == ed25519.PublicKeySize {
+		return ed25519.PublicKey(key), nil
+	}
+
+	// PKIX key, e.g. from "openssl pkey -pubout"
+	parsed, err := x509.ParsePKIXPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, ok := parsed.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("MANIFEST_PUBLIC_KEY is not an Ed25519 key")
+	}
+	return publicKey, nil
+}
+
+// readKey reads a base64 encoded key from the env key, or a PEM or base64 encoded key from
+// the file of the env key with "_FILE" suffix. It returns nil when none of them is set
+func readKey(envKey string) ([]byte, error) {
+	value := os.Getenv(envKey)
+	if filePath := os.Getenv(envKey + "_FILE"); value == "" && filePath != "" {
+		content, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+
+		if block, _ := pem.Decode(content); block != nil {
+			return block.Bytes, nil
+		}
+		value = string(content)
+	}
+
+	if value == "" {
+		return nil, nil
+	}
+
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+}
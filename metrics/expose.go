@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var lastRunFinished = NewGauge("xml_generator_last_run_finished_timestamp_seconds", "Unix time the last one-shot run finished")
+
+// Handler serves the metrics on /metrics in serve and daemon mode
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Write(w)
+	})
+}
+
+// ListenAndServe serves /metrics on "METRICS_ADDR" of .env file, defaults to :2112
+func ListenAndServe() error {
+	addr := os.Getenv("METRICS_ADDR")
+	if addr == "" {
+		addr = ":2112"
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+
+	fmt.Println("Serving metrics on " + addr)
+	return http.ListenAndServe(addr, mux)
+}
+
+// WriteTextfile writes the metrics of a one-shot run to "METRICS_TEXTFILE" of .env file for
+// the textfile collector of node_exporter, e.g. /var/lib/node_exporter/xml-generator.prom.
+// The file is written to a temporary file first so the collector never reads a partial file
+func WriteTextfile() {
+	filePath := os.Getenv("METRICS_TEXTFILE")
+	if filePath == "" {
+		return
+	}
+
+	lastRunFinished.Set(float64(time.Now().Unix()))
+
+	tmpFilePath := filepath.Join(filepath.Dir(filePath), "."+filepath.Base(filePath)+".tmp")
+	f, err := os.Create(tmpFilePath)
+	if err != nil {
+		fmt.Println("Unable to write metrics - " + err.Error())
+		return
+	}
+
+	Write(f)
+	if err = f.Close(); err == nil {
+		err = os.Rename(tmpFilePath, filePath)
+	}
+
+	if err != nil {
+		os.Remove(tmpFilePath)
+		fmt.Println("Unable to write metrics - " + err.Error())
+	}
+}
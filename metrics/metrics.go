@@ -0,0 +1,239 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets of a histogram in seconds, the same as the default buckets of Prometheus
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// metric is a family of series of the same name, one series per combination of label values
+type metric interface {
+	name() string
+	write(w io.Writer)
+}
+
+var registry []metric
+var registryMut sync.Mutex
+
+func register(m metric) {
+	registryMut.Lock()
+	defer registryMut.Unlock()
+	registry = append(registry, m)
+}
+
+// family keeps the series of a metric by its label values
+type family struct {
+	metricName string
+	help       string
+	metricType string
+	labelNames []string
+	mut        sync.Mutex
+}
+
+func (f *family) name() string {
+	return f.metricName
+}
+
+func (f *family) key(labelValues []string) string {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("%s expects labels %v, got %v", f.metricName, f.labelNames, labelValues))
+	}
+	return strings.Join(labelValues, "\xff")
+}
+
+func (f *family) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.metricName, helpEscaper.Replace(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.metricName, f.metricType)
+}
+
+// the text format only escapes backslash, double quote and line feed in label values and
+// backslash and line feed in help texts, Go escapes such as \u00e9 are not allowed
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func quoteLabel(value string) string {
+	return `"` + labelEscaper.Replace(value) + `"`
+}
+
+// labels formats the label pairs of a series, extra is appended as is, e.g. le="0.5"
+func (f *family) labels(key string, extra string) string {
+	var pairs []string
+	if len(f.labelNames) > 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			pairs = append(pairs, f.labelNames[i]+"="+quoteLabel(value))
+		}
+	}
+	if extra != "" {
+		pairs = append(pairs, extra)
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func sortedKeys(values map[string]float64) []string {
+	var keys []string
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// Counter only goes up, e.g. the number of parsed listings
+type Counter struct {
+	family
+	values map[string]float64
+}
+
+func NewCounter(name string, help string, labelNames ...string) *Counter {
+	c := &Counter{
+		family: family{metricName: name, help: help, metricType: "counter", labelNames: labelNames},
+		values: map[string]float64{},
+	}
+	register(c)
+	return c
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) Add(value float64, labelValues ...string) {
+	key := c.key(labelValues)
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.values[key] += value
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	c.writeHeader(w)
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.metricName, c.labels(key, ""), formatValue(c.values[key]))
+	}
+}
+
+// Gauge is set to the current value, e.g. the size of a feed
+type Gauge struct {
+	family
+	values map[string]float64
+}
+
+func NewGauge(name string, help string, labelNames ...string) *Gauge {
+	g := &Gauge{
+		family: family{metricName: name, help: help, metricType: "gauge", labelNames: labelNames},
+		values: map[string]float64{},
+	}
+	register(g)
+	return g
+}
+
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	key := g.key(labelValues)
+
+	g.mut.Lock()
+	defer g.mut.Unlock()
+	g.values[key] = value
+}
+
+func (g *Gauge) write(w io.Writer) {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	g.writeHeader(w)
+	for _, key := range sortedKeys(g.values) {
+		fmt.Fprintf(w, "%s%s %s\n", g.metricName, g.labels(key, ""), formatValue(g.values[key]))
+	}
+}
+
+// Histogram counts observations in buckets, e.g. the duration of the DB queries
+type Histogram struct {
+	family
+	buckets []float64
+	counts  map[string][]uint64
+	sums    map[string]float64
+}
+
+func NewHistogram(name string, help string, buckets []float64, labelNames ...string) *Histogram {
+	h := &Histogram{
+		family:  family{metricName: name, help: help, metricType: "histogram", labelNames: labelNames},
+		buckets: buckets,
+		counts:  map[string][]uint64{},
+		sums:    map[string]float64{},
+	}
+	register(h)
+	return h
+}
+
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	key := h.key(labelValues)
+
+	h.mut.Lock()
+	defer h.mut.Unlock()
+
+	// the last count is the +Inf bucket
+	counts, ok := h.counts[key]
+	if !ok {
+		counts = make([]uint64, len(h.buckets)+1)
+		h.counts[key] = counts
+	}
+
+	for i, bucket := range h.buckets {
+		if value <= bucket {
+			counts[i]++
+		}
+	}
+	counts[len(h.buckets)]++
+	h.sums[key] += value
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+
+	h.writeHeader(w)
+	for _, key := range sortedKeys(h.sums) {
+		counts := h.counts[key]
+		for i, bucket := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, h.labels(key, "le="+quoteLabel(formatValue(bucket))), counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, h.labels(key, `le="+Inf"`), counts[len(h.buckets)])
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName, h.labels(key, ""), formatValue(h.sums[key]))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metricName, h.labels(key, ""), counts[len(h.buckets)])
+	}
+}
+
+// Write writes every registered metric in the Prometheus text format
+func Write(w io.Writer) {
+	registryMut.Lock()
+	metrics := append([]metric(nil), registry...)
+	registryMut.Unlock()
+
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].name() < metrics[j].name()
+	})
+
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
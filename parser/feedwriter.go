@@ -0,0 +1,140 @@
+package parser
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"bitbucket.org/waseka/waseka-xml-generator/utils"
+)
+
+const feedHeader = "<rubrikk>\n"
+const feedFooter = "</rubrikk>\n"
+
+// FeedWriter writes the adverts of a category to complete <rubrikk> documents on feeds directory.
+// When "FEED_MAX_BYTES" or "FEED_MAX_ADVERTS" of .env file is set, it rolls over to
+// feed1-001.xml, feed1-002.xml, ... once the threshold of the current part is reached
+type FeedWriter struct {
+	category   string
+	maxBytes   int
+	maxAdverts int
+	part       int
+	file       *os.File
+	bytes      int
+	adverts    int
+	total      int
+	Files      []string
+}
+
+// writeError is an I/O error of the feed, e.g. a full disk, it fails the category instead
+// of a single listing since the part file may hold half an advert
+type writeError struct {
+	err error
+}
+
+func (e writeError) Error() string {
+	return "unable to write feed - " + e.err.Error()
+}
+
+func NewFeedWriter(propertyCategory string) *FeedWriter {
+	return &FeedWriter{
+		category:   propertyCategory,
+		maxBytes:   envInt("FEED_MAX_BYTES"),
+		maxAdverts: envInt("FEED_MAX_ADVERTS"),
+	}
+}
+
+func envInt(key string) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return 0
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		panic(fmt.Sprintf("Invalid %s in .env file - %s", key, err.Error()))
+	}
+	return number
+}
+
+func (w *FeedWriter) isSplit() bool {
+	return w.maxBytes > 0 || w.maxAdverts > 0
+}
+
+func (w *FeedWriter) isFull(size int) bool {
+	if w.adverts == 0 {
+		return false
+	}
+	if w.maxAdverts > 0 && w.adverts >= w.maxAdverts {
+		return true
+	}
+	return w.maxBytes > 0 && w.bytes+size+len(feedFooter) > w.maxBytes
+}
+
+func (w *FeedWriter) fileName() string {
+	fileName := utils.FileNameMap[w.category]
+	if !w.isSplit() {
+		return fileName
+	}
+
+	return fmt.Sprintf("%s-%03d.xml", strings.TrimSuffix(fileName, ".xml"), w.part)
+}
+
+func (w *FeedWriter) open() {
+	w.part++
+	filePath := "feeds/" + w.fileName()
+
+	f, err := os.OpenFile(filePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0777)
+	if err != nil {
+		panic(writeError{err})
+	}
+
+	w.file = f
+	w.bytes = 0
+	w.adverts = 0
+	w.Files = append(w.Files, filePath)
+	w.write([]byte(feedHeader))
+}
+
+func (w *FeedWriter) write(output []byte) {
+	n, err := w.file.Write(output)
+	if err != nil {
+		panic(writeError{err})
+	}
+	w.bytes += n
+	w.total += n
+}
+
+// Write appends a marshalled advert to the current part of the feed
+func (w *FeedWriter) Write(advert []byte) {
+	advert = append(advert, "\n"...)
+
+	if w.file != nil && w.isFull(len(advert)) {
+		w.Close()
+	}
+	if w.file == nil {
+		w.open()
+	}
+
+	w.write(advert)
+	w.adverts++
+}
+
+// Size returns the bytes written to every part of the feed
+func (w *FeedWriter) Size() int {
+	return w.total
+}
+
+// Close completes the current part of the feed with the closing </rubrikk> tag
+func (w *FeedWriter) Close() {
+	if w.file == nil {
+		return
+	}
+
+	w.write([]byte(feedFooter))
+	if err := w.file.Close(); err != nil {
+		panic(writeError{err})
+	}
+	w.file = nil
+}
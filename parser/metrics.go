@@ -0,0 +1,9 @@
+package parser
+
+import "bitbucket.org/waseka/waseka-xml-generator/metrics"
+
+var listingsTotal = metrics.NewCounter("xml_generator_listings_total", "Listings by category and result, parsed, skipped (no price) or failed", "category", "result")
+var queryDuration = metrics.NewHistogram("xml_generator_db_query_duration_seconds", "Duration of the MySQL queries of the parser", metrics.DefBuckets, "query")
+var geolytixCacheTotal = metrics.NewCounter("xml_generator_geolytix_cache_total", "Lookups of the city by postcode, a hit or a miss of the geolytix cache", "result")
+var feedSizeBytes = metrics.NewGauge("xml_generator_feed_size_bytes", "Size of the generated feeds of a category, every part included", "category")
+var feedAdverts = metrics.NewGauge("xml_generator_feed_adverts", "Adverts of the generated feeds of a category", "category")
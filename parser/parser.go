@@ -3,22 +3,22 @@ package parser
 import (
 	"database/sql"
 	"encoding/json"
-	"encoding/xml"
 	"fmt"
-	"log"
 	"math"
 	"os"
-	"os/exec"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"bitbucket.org/waseka/waseka-xml-generator/feed"
+	"bitbucket.org/waseka/waseka-xml-generator/logger"
+	"bitbucket.org/waseka/waseka-xml-generator/sitemap"
+	"bitbucket.org/waseka/waseka-xml-generator/syndication"
 	"bitbucket.org/waseka/waseka-xml-generator/utils"
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/joho/godotenv"
-	"github.com/shopspring/decimal"
 )
 
 const LIMIT = 1000
@@ -26,45 +26,48 @@ const LIMIT = 1000
 var totalNumberPropertyParsed int
 var category string
 var allXMLParsedPropertyIds []string
+var feedWriter *FeedWriter
+var summary Summary
+var categoryLog *logger.Logger
 
 var wg sync.WaitGroup
 var mut sync.Mutex
-
-type RubrikkAdvert struct {
-	XMLName              xml.Name        `xml:"ad"`
-	Id                   int             `xml:"ad__number_reference_id"`
-	AdHeadline           string          `xml:"ad__headline"`
-	Description          string          `xml:"ad__description"`
-	Price                decimal.Decimal `xml:"ad__price"`
-	PriceCurrency        string          `xml:"ad__price_currency"`
-	CompanyURL           string          `xml:"advertiser__company_homepage_url"`
-	Mobile               string          `xml:"advertiser__mobile"`
-	Phone                string          `xml:"advertiser__phone"`
-	URL                  string          `xml:"ad__url"`
-	Thumbnail            string          `xml:"ad__imageurl"`
-	AdvertImages         []string        `xml:"ad__all_imageurls>image"`
-	MainCategoryOriginal string          `xml:"maincategory_original"`
-	CategoryOriginal     string          `xml:"category_original"`
-	MunicipalityCity     string          `xml:"location__municipality_city"`
-	PostalName           string          `xml:"location__postal_name"`
-	Postcode             string          `xml:"location__zip_postal_code"`
-	Lat                  float32         `xml:"location__latitude"`
-	Lng                  float32         `xml:"location__longitude"`
-	StreetAddress        string          `xml:"location__streetaddress"`
-	Bed                  int32           `xml:"real_estate__beds,omitempty"`
-	Bathroom             int32           `xml:"real_estate__number_of_bathrooms,omitempty"`
+var errMut sync.Mutex
+var workerPanic interface{}
+
+// city of a postcode from geolytix_locations, many listings share a postcode
+var geolytixCache map[string]string
+var geolytixMut sync.RWMutex
+
+// Summary of a parsed category, listings without price are skipped and listings which
+// failed to parse are left out of the feed
+type Summary struct {
+	Parsed  int
+	Skipped int
+	Failed  int
+	Files   []string
+	Error   string
 }
 
 func initialLoad(propertyCategory string) {
 	// intial setup
 	totalNumberPropertyParsed = 0
 	category = propertyCategory
+	allXMLParsedPropertyIds = nil
+	summary = Summary{}
+	categoryLog = logger.With("category", propertyCategory)
+
+	geolytixMut.Lock()
+	geolytixCache = map[string]string{}
+	geolytixMut.Unlock()
 }
 
 func totalRecords(db *sql.DB) int {
 	today := time.Now().Local().Format("2006-01-02")
 	query := fmt.Sprintf("SELECT count(*) from %s where published_at is not null and date(expired_at) > \"%s\" and active_at is not null and deleted_at is null and is_sold is null and is_synced = 1", utils.PropertyTableMap[category], today)
+	queryStart := time.Now()
 	rows, err := db.Query(query)
+	queryDuration.Observe(time.Since(queryStart).Seconds(), "count")
 
 	if err != nil {
 		panic(err.Error())
@@ -81,15 +84,18 @@ func totalRecords(db *sql.DB) int {
 	return count
 }
 
-func ParseToXML(propertyCategory string) {
+func ParseToXML(propertyCategory string) Summary {
 	// setup intial dependency
 	initialLoad(propertyCategory)
 
 	err := godotenv.Load()
+	// a panic fails the run, the scheduler of the daemon recovers and retries it
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		panic("Error loading .env file - " + err.Error())
 	}
 
+	feedWriter = NewFeedWriter(category)
+
 	db, err := sql.Open("mysql", os.Getenv("MYSQL_USER")+":"+os.Getenv("MYSQL_PASSWORD")+"@tcp("+os.Getenv("MYSQL_HOST")+":"+os.Getenv("MYSQL_PORT")+")/"+os.Getenv("MYSQL_DATABASE"))
 
 	if err != nil {
@@ -107,34 +113,55 @@ func ParseToXML(propertyCategory string) {
 	}
 
 	wg.Wait()
+	feedWriter.Close()
+	feedSizeBytes.Set(float64(feedWriter.Size()), category)
+	feedAdverts.Set(float64(totalNumberPropertyParsed), category)
+
+	if workerPanic != nil {
+		r := workerPanic
+		workerPanic = nil
+		panic(r)
+	}
 
 	if len(allXMLParsedPropertyIds) > 0 {
 		updateProperty()
 	}
 
-	if totalNumberPropertyParsed > 0 {
-		updateXML("feeds/" + utils.FileNameMap[category])
-		createLog()
-	}
+	summary.Parsed = totalNumberPropertyParsed
+	summary.Files = feedWriter.Files
+
+	categoryLog.Info("Category parsed",
+		"parsed", summary.Parsed,
+		"skipped", summary.Skipped,
+		"failed", summary.Failed,
+		"files", strings.Join(summary.Files, ","),
+	)
+	return summary
 }
 
-func createLog() {
-	f, err := os.OpenFile("log.txt", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+func getCityNameByPostcode(postcode string) string {
+	postcode = strings.ToLower(strings.ReplaceAll(postcode, " ", ""))
 
-	if err != nil {
-		panic(err.Error())
-	}
-	now := time.Now().Local().Format("2006-01-02 15:04:05")
-	output := []byte("[" + now + "] - Total " + strings.ToUpper(category) + " properties parsed - " + strconv.Itoa(totalNumberPropertyParsed))
-	_, err = f.Write([]byte(append(output, "\n"...)))
-	if err != nil {
-		panic(err.Error())
+	geolytixMut.RLock()
+	city, ok := geolytixCache[postcode]
+	geolytixMut.RUnlock()
+
+	if ok {
+		geolytixCacheTotal.Inc("hit")
+		return city
 	}
+	geolytixCacheTotal.Inc("miss")
+
+	city = queryCityNameByPostcode(postcode)
 
-	f.Close()
+	geolytixMut.Lock()
+	geolytixCache[postcode] = city
+	geolytixMut.Unlock()
+
+	return city
 }
 
-func getCityNameByPostcode(postcode string) string {
+func queryCityNameByPostcode(postcode string) string {
 	db, err := sql.Open("mysql", os.Getenv("MYSQL_USER")+":"+os.Getenv("MYSQL_PASSWORD")+"@tcp("+os.Getenv("MYSQL_HOST")+":"+os.Getenv("MYSQL_PORT")+")/"+os.Getenv("MYSQL_DATABASE"))
 
 	if err != nil {
@@ -143,11 +170,10 @@ func getCityNameByPostcode(postcode string) string {
 
 	defer db.Close()
 
-	postcode = strings.ToLower(strings.ReplaceAll(postcode, " ", ""))
-	// fmt.Println(postcode)
-
 	query := fmt.Sprintf("SELECT place, searchable_keyword from geolytix_locations where searchable_keyword = \"%s\"", postcode)
+	queryStart := time.Now()
 	rows, err := db.Query(query)
+	queryDuration.Observe(time.Since(queryStart).Seconds(), "geolytix")
 	if err != nil {
 		panic(err.Error())
 	}
@@ -168,9 +194,24 @@ func getCityNameByPostcode(postcode string) string {
 	return ""
 }
 
+// recoverWorker keeps the first panic of the workers, ParseToXML raises it again once
+// every worker is done, so that a daemon run can recover from it
+func recoverWorker() {
+	if r := recover(); r != nil {
+		errMut.Lock()
+		defer errMut.Unlock()
+		if workerPanic == nil {
+			workerPanic = r
+		}
+		categoryLog.Error("Batch failed", "error", fmt.Sprint(r))
+	}
+}
+
 func execute(offset int) {
-	db, err := sql.Open("mysql", os.Getenv("MYSQL_USER")+":"+os.Getenv("MYSQL_PASSWORD")+"@tcp("+os.Getenv("MYSQL_HOST")+":"+os.Getenv("MYSQL_PORT")+")/"+os.Getenv("MYSQL_DATABASE"))
 	defer wg.Done()
+	defer recoverWorker()
+
+	db, err := sql.Open("mysql", os.Getenv("MYSQL_USER")+":"+os.Getenv("MYSQL_PASSWORD")+"@tcp("+os.Getenv("MYSQL_HOST")+":"+os.Getenv("MYSQL_PORT")+")/"+os.Getenv("MYSQL_DATABASE"))
 
 	if err != nil {
 		panic(err.Error())
@@ -178,93 +219,136 @@ func execute(offset int) {
 
 	today := time.Now().Local().Format("2006-01-02")
 	query := fmt.Sprintf("SELECT ab.id as branch_id, ab.branch_name, ab.contact_phone, p.id, p.agent_branch_id, p.property_type, p.price, p.price_type, p.postcode, p.address_line1, p.short_description, p.city, p.lat, p.lng, p.bed, p.bathroom, p.property_images, p.thumbnail, p.is_sold, p.is_xml_parsed, p.published_at, p.expired_at, p.active_at, p.deleted_at, p.is_synced from %s as p, agent_branches as ab where p.agent_branch_id = ab.id and p.published_at is not null and date(p.expired_at) > \"%s\" and p.active_at is not null and p.deleted_at is null and p.is_sold is null and p.is_synced = 1 limit %s, %s", utils.PropertyTableMap[category], today, strconv.Itoa(offset), strconv.Itoa(LIMIT))
+	queryStart := time.Now()
 	results, err := db.Query(query)
+	queryDuration.Observe(time.Since(queryStart).Seconds(), "listings")
 	if err != nil {
 		panic(err.Error())
 	}
 	db.Close()
 
-	fmt.Println(offset)
+	categoryLog.Info("Batch queried", "offset", offset, "limit", LIMIT)
 
 	for results.Next() {
-		var property utils.Property
-
-		err = results.Scan(
-			&property.BranchId,
-			&property.BranchName,
-			&property.Mobile,
-			&property.Id,
-			&property.AgentBranchId,
-			&property.PropertyType,
-			&property.Price,
-			&property.PriceType,
-			&property.Postcode,
-			&property.StreetAddress,
-			&property.ShortDescription,
-			&property.City,
-			&property.Lat,
-			&property.Lng,
-			&property.Bed,
-			&property.Bathroom,
-			&property.PropertyImages,
-			&property.Thumbnail,
-			&property.IsSold,
-			&property.IsXmlParsed,
-			&property.PublishedAt,
-			&property.ExpiredAt,
-			&property.ActiveAt,
-			&property.DeletedAt,
-			&property.IsSynced,
-		)
-
-		if err != nil {
-			panic(err.Error())
-		}
-
-		// Setting property city as postalname
-		property.PostalName = property.City
+		processListing(results)
+	}
+}
 
-		// Get the city name from geolytix_locations table by another sql query
-		// If not found then the previous city name will be remain
-		if city := getCityNameByPostcode(property.Postcode); city != "" {
-			property.City = city
+// recoverListing counts a listing which failed as failed and keeps the first error, the
+// other listings of the batch are still parsed. A write error of the feed fails the batch
+func recoverListing(id *int) {
+	if r := recover(); r != nil {
+		if _, ok := r.(writeError); ok {
+			panic(r)
 		}
 
-		// Residential bed setup
-		if category == "residential-for-sale" || category == "residential-to-rent" {
-			// If bed is 0 then bed should be 1 and bathroom should be 1
-			if property.Bed.Valid && property.Bed.Int32 == 0 {
-				property.Bed.Int32 = 1
-				property.Bathroom.Int32 = 1
-			}
-		}
-		// Commercial bed and bathroom ignore
-		if category == "commercial-for-sale" || category == "commercial-to-rent" {
-			property.Bed.Int32 = 0
-			property.Bathroom.Int32 = 0
+		errMut.Lock()
+		defer errMut.Unlock()
+		summary.Failed++
+		listingsTotal.Inc(category, "failed")
+		categoryLog.Error("Listing failed", "listing_id", *id, "error", fmt.Sprint(r))
+		if summary.Error == "" {
+			summary.Error = fmt.Sprintf("listing %d - %v", *id, r)
 		}
+	}
+}
 
-		type Image struct {
-			URL string
-		}
+func processListing(results *sql.Rows) {
+	var property utils.Property
+	defer recoverListing(&property.Id)
+
+	err := results.Scan(
+		&property.BranchId,
+		&property.BranchName,
+		&property.Mobile,
+		&property.Id,
+		&property.AgentBranchId,
+		&property.PropertyType,
+		&property.Price,
+		&property.PriceType,
+		&property.Postcode,
+		&property.StreetAddress,
+		&property.ShortDescription,
+		&property.City,
+		&property.Lat,
+		&property.Lng,
+		&property.Bed,
+		&property.Bathroom,
+		&property.PropertyImages,
+		&property.Thumbnail,
+		&property.IsSold,
+		&property.IsXmlParsed,
+		&property.PublishedAt,
+		&property.ExpiredAt,
+		&property.ActiveAt,
+		&property.DeletedAt,
+		&property.IsSynced,
+	)
+
+	if err != nil {
+		panic(err.Error())
+	}
 
-		type ImageList struct {
-			Gallery []Image
+	// Setting property city as postalname
+	property.PostalName = property.City
+
+	// Get the city name from geolytix_locations table by another sql query
+	// If not found then the previous city name will be remain
+	if city := getCityNameByPostcode(property.Postcode); city != "" {
+		property.City = city
+	}
+
+	// Residential bed setup
+	if category == "residential-for-sale" || category == "residential-to-rent" {
+		// If bed is 0 then bed should be 1 and bathroom should be 1
+		if property.Bed.Valid && property.Bed.Int32 == 0 {
+			property.Bed.Int32 = 1
+			property.Bathroom.Int32 = 1
 		}
+	}
+	// Commercial bed and bathroom ignore
+	if category == "commercial-for-sale" || category == "commercial-to-rent" {
+		property.Bed.Int32 = 0
+		property.Bathroom.Int32 = 0
+	}
+
+	type Image struct {
+		URL string
+	}
+
+	type ImageList struct {
+		Gallery []Image
+	}
+
+	var imageList ImageList
+	json.Unmarshal([]byte(property.PropertyImages), &imageList)
+
+	for i := 0; i < len(imageList.Gallery); i++ {
+		property.AdvertImages = append(property.AdvertImages, imageList.Gallery[i].URL)
+	}
 
-		var imageList ImageList
-		json.Unmarshal([]byte(property.PropertyImages), &imageList)
+	if sitemap.IsEnabled() {
+		sitemap.Add(property, category)
+	}
 
-		for i := 0; i < len(imageList.Gallery); i++ {
-			property.AdvertImages = append(property.AdvertImages, imageList.Gallery[i].URL)
+	if property.Price.Valid {
+		if syndication.IsEnabled() {
+			syndication.Add(property, category)
 		}
 
-		if property.Price.Valid {
+		func() {
 			mut.Lock()
+			defer mut.Unlock()
 			createXML(property)
 			allXMLParsedPropertyIds = append(allXMLParsedPropertyIds, strconv.Itoa(property.Id))
-			mut.Unlock()
-		}
+		}()
+	} else {
+		// listings without price are not advertised
+		errMut.Lock()
+		summary.Skipped++
+		errMut.Unlock()
+		listingsTotal.Inc(category, "skipped")
+		categoryLog.Debug("Listing skipped without price", "listing_id", property.Id)
 	}
 }
 
@@ -275,21 +359,19 @@ func updateProperty() {
 		panic(err.Error())
 	}
 
-	_, err = db.Exec("UPDATE " + utils.PropertyTableMap[category] + " SET is_xml_parsed = 1 WHERE id in (" + strings.Join(allXMLParsedPropertyIds, ", ") + ")")
-	if err != nil {
-		panic(err.Error())
-	}
-}
+	queryStart := time.Now()
+	defer func() {
+		queryDuration.Observe(time.Since(queryStart).Seconds(), "update")
+	}()
 
-func updateXML(filePath string) {
-	_, err := exec.Command("/bin/sh", "bash.sh", filePath).Output()
+	_, err = db.Exec("UPDATE " + utils.PropertyTableMap[category] + " SET is_xml_parsed = 1 WHERE id in (" + strings.Join(allXMLParsedPropertyIds, ", ") + ")")
 	if err != nil {
 		panic(err.Error())
 	}
 }
 
 func createXML(property utils.Property) {
-	rubrikkAdvert := RubrikkAdvert{
+	advert := feed.Advert{
 		Id:                   property.Id,
 		CompanyURL:           utils.CompanyURL(property.BranchName, property.BranchId),
 		Mobile:               property.Mobile.String,
@@ -312,20 +394,10 @@ func createXML(property utils.Property) {
 		Bed:                  property.Bed.Int32,
 		Bathroom:             property.Bathroom.Int32,
 	}
-	output, _ := xml.MarshalIndent(rubrikkAdvert, "   ", "    ")
-	fileName := utils.FileNameMap[category]
-	f, err := os.OpenFile("feeds/"+fileName+"", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0777)
-
-	if err != nil {
-		panic(err.Error())
-	}
-
-	_, err = f.Write([]byte(append(output, "\n"...)))
-	if err != nil {
-		panic(err.Error())
-	}
-
-	f.Close()
+	output, _ := advert.Marshal()
+	feedWriter.Write(output)
 
 	totalNumberPropertyParsed++
+	listingsTotal.Inc(category, "parsed")
+	categoryLog.Debug("Listing parsed", "listing_id", property.Id)
 }
@@ -0,0 +1,42 @@
+//go:build !windows
+// +build !windows
+
+package runlock
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// FileLock holds a flock on the lock file, the kernel releases it when the process exits,
+// so a crashed run never leaves a stale lock behind
+type FileLock struct {
+	file *os.File
+}
+
+func acquireFile(filePath string) (Lock, error) {
+	f, err := os.OpenFile(filePath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, ErrLocked
+		}
+		return nil, err
+	}
+
+	// pid of the run holding the lock, for whoever wonders which run is in progress
+	f.Truncate(0)
+	f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+
+	return &FileLock{file: f}, nil
+}
+
+func (l *FileLock) Release() error {
+	defer l.file.Close()
+	return syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,10 @@
+//go:build windows
+// +build windows
+
+package runlock
+
+import "errors"
+
+func acquireFile(filePath string) (Lock, error) {
+	return nil, errors.New("file run lock is not supported on windows, use RUN_LOCK_MODE=mysql")
+}
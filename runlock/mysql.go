@@ -0,0 +1,55 @@
+package runlock
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+// MySQLLock holds a GET_LOCK named lock, MySQL keeps it as long as the connection
+// which took it is open, so the connection is kept until Release
+type MySQLLock struct {
+	name string
+	db   *sql.DB
+	conn *sql.Conn
+}
+
+func acquireMySQL(name string) (Lock, error) {
+	db, err := sql.Open("mysql", os.Getenv("MYSQL_USER")+":"+os.Getenv("MYSQL_PASSWORD")+"@tcp("+os.Getenv("MYSQL_HOST")+":"+os.Getenv("MYSQL_PORT")+")/"+os.Getenv("MYSQL_DATABASE"))
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	var acquired sql.NullInt64
+	err = conn.QueryRowContext(context.Background(), "SELECT GET_LOCK(?, 0)", name).Scan(&acquired)
+	if err == nil && !acquired.Valid {
+		err = fmt.Errorf("GET_LOCK of %q failed", name)
+	}
+	if err == nil && acquired.Int64 == 1 {
+		return &MySQLLock{name: name, db: db, conn: conn}, nil
+	}
+
+	conn.Close()
+	db.Close()
+	if err != nil {
+		return nil, err
+	}
+	return nil, ErrLocked
+}
+
+func (l *MySQLLock) Release() error {
+	defer l.db.Close()
+	defer l.conn.Close()
+
+	_, err := l.conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", l.name)
+	return err
+}
@@ -0,0 +1,36 @@
+package runlock
+
+import (
+	"errors"
+	"os"
+)
+
+// exit code of a run which found another run in progress, EX_TEMPFAIL of sysexits.h,
+// so that cron wrappers can tell it apart from a failed run
+const EXIT_CODE = 75
+
+var ErrLocked = errors.New("another run is in progress")
+
+type Lock interface {
+	Release() error
+}
+
+// Acquire takes the exclusive run lock of "RUN_LOCK_MODE" of .env file without waiting.
+// "file" (default) locks "RUN_LOCK_FILE" on this host, "mysql" takes the MySQL named lock
+// "RUN_LOCK_NAME" for deployments running on several hosts. It returns ErrLocked when
+// another run holds the lock
+func Acquire() (Lock, error) {
+	if os.Getenv("RUN_LOCK_MODE") == "mysql" {
+		name := os.Getenv("RUN_LOCK_NAME")
+		if name == "" {
+			name = "waseka-xml-generator"
+		}
+		return acquireMySQL(name)
+	}
+
+	filePath := os.Getenv("RUN_LOCK_FILE")
+	if filePath == "" {
+		filePath = "xml-generator.lock"
+	}
+	return acquireFile(filePath)
+}
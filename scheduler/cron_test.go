@@ -0,0 +1,135 @@
+package scheduler
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func fieldValues(values map[int]bool) []int {
+	var sorted []int
+	for value := range values {
+		sorted = append(sorted, value)
+	}
+	sort.Ints(sorted)
+	return sorted
+}
+
+func TestParseField(t *testing.T) {
+	tests := []struct {
+		field string
+		min   int
+		max   int
+		want  []int
+	}{
+		{"*", 0, 5, []int{0, 1, 2, 3, 4, 5}},
+		{"3", 0, 59, []int{3}},
+		{"1,5-7", 0, 59, []int{1, 5, 6, 7}},
+		{"*/20", 0, 59, []int{0, 20, 40}},
+		{"10-20/5", 0, 59, []int{10, 15, 20}},
+		{"5/20", 0, 59, []int{5, 25, 45}},
+		{"1-5,*/15", 0, 59, []int{0, 1, 2, 3, 4, 5, 15, 30, 45}},
+		{"*/10", 1, 31, []int{1, 11, 21, 31}},
+	}
+
+	for _, test := range tests {
+		values, err := parseField(test.field, test.min, test.max)
+		if err != nil {
+			t.Errorf("%q: %v", test.field, err)
+			continue
+		}
+		if got := fieldValues(values); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %v, want %v", test.field, got, test.want)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	expressions := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"1-x * * * *",
+		"@every",
+	}
+
+	for _, expression := range expressions {
+		if _, err := Parse(expression); err == nil {
+			t.Errorf("%q: got no error", expression)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	at := func(value string) time.Time {
+		parsed, err := time.Parse("2006-01-02 15:04:05", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+
+	// 2024-03-01 is a friday
+	tests := []struct {
+		expression string
+		from       string
+		want       string
+	}{
+		{"*/15 * * * *", "2024-03-01 10:07:30", "2024-03-01 10:15:00"},
+		{"*/15 * * * *", "2024-03-01 10:45:00", "2024-03-01 11:00:00"},
+		{"0 * * * *", "2024-03-01 10:00:00", "2024-03-01 11:00:00"},
+		{"10-20/5 * * * *", "2024-03-01 10:12:00", "2024-03-01 10:15:00"},
+		{"10-20/5 * * * *", "2024-03-01 10:20:00", "2024-03-01 11:10:00"},
+		{"5/20 * * * *", "2024-03-01 10:30:00", "2024-03-01 10:45:00"},
+		{"30 6-22 * * 1-5", "2024-03-01 22:30:00", "2024-03-04 06:30:00"},
+		{"0 0 1 * *", "2024-12-15 00:00:00", "2025-01-01 00:00:00"},
+		{"0 0 29 2 *", "2024-03-01 00:00:00", "2028-02-29 00:00:00"},
+		// a restricted day-of-month with any day-of-week matches the day-of-month only
+		{"0 0 13 * *", "2024-03-01 00:00:00", "2024-03-13 00:00:00"},
+		// a restricted day-of-week with any day-of-month matches the day-of-week only
+		{"0 0 * * 3", "2024-03-01 00:00:00", "2024-03-06 00:00:00"},
+		// both restricted match either one, the 13th or a friday
+		{"0 0 13 * 5", "2024-03-01 00:00:00", "2024-03-08 00:00:00"},
+		{"0 0 13 * 5", "2024-03-08 00:00:00", "2024-03-13 00:00:00"},
+		// both 0 and 7 are sunday
+		{"0 12 * * 0", "2024-03-01 00:00:00", "2024-03-03 12:00:00"},
+		{"0 12 * * 7", "2024-03-01 00:00:00", "2024-03-03 12:00:00"},
+		{"0 12 * * 5-7", "2024-03-02 13:00:00", "2024-03-03 12:00:00"},
+		{"@hourly", "2024-03-01 10:59:59", "2024-03-01 11:00:00"},
+		{"@daily", "2024-03-01 10:00:00", "2024-03-02 00:00:00"},
+		{"@weekly", "2024-03-01 10:00:00", "2024-03-03 00:00:00"},
+		{"@monthly", "2024-03-01 00:00:00", "2024-04-01 00:00:00"},
+		{"@yearly", "2024-03-01 00:00:00", "2025-01-01 00:00:00"},
+	}
+
+	for _, test := range tests {
+		schedule, err := Parse(test.expression)
+		if err != nil {
+			t.Errorf("%q: %v", test.expression, err)
+			continue
+		}
+		if got := schedule.Next(at(test.from)); !got.Equal(at(test.want)) {
+			t.Errorf("%q from %s: got %s, want %s", test.expression, test.from, got.Format("2006-01-02 15:04:05"), test.want)
+		}
+	}
+}
+
+func TestNextNever(t *testing.T) {
+	schedule, err := Parse("0 0 31 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := schedule.Next(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)); !next.IsZero() {
+		t.Errorf("got %s, want no matching date", next)
+	}
+}
@@ -0,0 +1,154 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// longest wait between two retries of a failed job
+const MAX_BACKOFF = time.Hour
+
+// first wait before retrying a failed job when the backoff is not positive, a zero backoff
+// would retry in a tight loop
+const DEFAULT_BACKOFF = time.Minute
+
+type Job struct {
+	Name     string
+	Schedule *Schedule
+	Run      func() error
+}
+
+// Scheduler runs its jobs on their cron schedules, one job at a time since every job
+// works on the same feeds directory
+type Scheduler struct {
+	// random delay up to Jitter before every scheduled run
+	Jitter time.Duration
+	// first delay before retrying a failed run, doubled on every further failure
+	Backoff time.Duration
+	jobs    []Job
+	mut     sync.Mutex
+	// the global source is not seeded before go 1.20, every restart would get the same
+	// jitter
+	random    *rand.Rand
+	randomMut sync.Mutex
+}
+
+func New(jitter time.Duration, backoff time.Duration) *Scheduler {
+	if backoff <= 0 {
+		backoff = DEFAULT_BACKOFF
+	}
+	return &Scheduler{Jitter: jitter, Backoff: backoff, random: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (s *Scheduler) jitter() time.Duration {
+	s.randomMut.Lock()
+	defer s.randomMut.Unlock()
+
+	return time.Duration(s.random.Int63n(int64(s.Jitter)))
+}
+
+func (s *Scheduler) Add(name string, expression string, run func() error) error {
+	schedule, err := Parse(expression)
+	if err != nil {
+		return fmt.Errorf("%s - %s", name, err.Error())
+	}
+
+	s.jobs = append(s.jobs, Job{Name: name, Schedule: schedule, Run: run})
+	fmt.Printf("Scheduled %s at %q, next run at %s\n", name, expression, schedule.Next(time.Now()).Format("2006-01-02 15:04"))
+	return nil
+}
+
+// Run blocks until the context is cancelled, a job already running is finished first
+func (s *Scheduler) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	for _, job := range s.jobs {
+		wg.Add(1)
+		go func(job Job) {
+			defer wg.Done()
+			s.loop(ctx, job)
+		}(job)
+	}
+
+	wg.Wait()
+}
+
+func (s *Scheduler) loop(ctx context.Context, job Job) {
+	for {
+		next := job.Schedule.Next(time.Now())
+		if next.IsZero() {
+			fmt.Println(job.Name + " never runs, its schedule has no matching date")
+			return
+		}
+
+		if s.Jitter > 0 {
+			next = next.Add(s.jitter())
+		}
+
+		if !sleep(ctx, time.Until(next)) {
+			return
+		}
+
+		backoff := s.Backoff
+		for {
+			err := s.execute(ctx, job)
+			if err == nil {
+				break
+			}
+
+			// a retry never runs into the next scheduled run
+			if backoff > MAX_BACKOFF {
+				backoff = MAX_BACKOFF
+			}
+			if time.Now().Add(backoff).After(job.Schedule.Next(time.Now())) {
+				fmt.Printf("%s failed - %s, waiting for the next scheduled run\n", job.Name, err.Error())
+				break
+			}
+
+			fmt.Printf("%s failed - %s, retrying in %s\n", job.Name, err.Error(), backoff)
+			if !sleep(ctx, backoff) {
+				return
+			}
+			backoff *= 2
+		}
+	}
+}
+
+func (s *Scheduler) execute(ctx context.Context, job Job) (err error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	// shutdown was requested while waiting for another job
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	// the parser panics on errors, a failed run must not stop the daemon
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	start := time.Now()
+	fmt.Println(job.Name + " started")
+	err = job.Run()
+	fmt.Println(job.Name+" finished in", time.Since(start))
+
+	return err
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,285 @@
+package server
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bitbucket.org/waseka/waseka-xml-generator/exporter"
+	"bitbucket.org/waseka/waseka-xml-generator/utils"
+)
+
+var mut sync.Mutex
+
+// Partner is a consumer of the feeds of "SERVE_PARTNERS" of .env file, e.g. "acme:secret"
+type Partner struct {
+	Name   string
+	Secret string
+}
+
+type Server struct {
+	Root     string
+	Auth     string
+	Partners []Partner
+	mux      *http.ServeMux
+}
+
+// New refuses an unknown "SERVE_AUTH", e.g. a typo would serve the feeds without auth
+func New() (*Server, error) {
+	s := &Server{
+		Root: os.Getenv("EXPORT_PATH"),
+		Auth: os.Getenv("SERVE_AUTH"),
+		mux:  http.NewServeMux(),
+	}
+
+	switch s.Auth {
+	case "":
+		s.Auth = "none"
+	case "none", "basic", "token":
+	default:
+		return nil, fmt.Errorf("unknown SERVE_AUTH %q, expected none, basic or token", s.Auth)
+	}
+
+	for _, partner := range strings.Split(os.Getenv("SERVE_PARTNERS"), ",") {
+		nameAndSecret := strings.SplitN(strings.TrimSpace(partner), ":", 2)
+		if len(nameAndSecret) == 2 {
+			s.Partners = append(s.Partners, Partner{Name: nameAndSecret[0], Secret: nameAndSecret[1]})
+		}
+	}
+
+	s.mux.HandleFunc("/", s.serveFile)
+	return s, nil
+}
+
+// ListenAndServe serves the exported feeds on "SERVE_ADDR" of .env file, defaults to :5000
+// which nginx/default.conf proxies to
+func (s *Server) ListenAndServe() error {
+	addr := os.Getenv("SERVE_ADDR")
+	if addr == "" {
+		addr = ":5000"
+	}
+
+	fmt.Println("Serving " + s.Root + " on " + addr)
+	return http.ListenAndServe(addr, s.mux)
+}
+
+// isPublic allows feed.xml, the manifest and the files of the "ExportDirectories" of utils,
+// the releases and everything else of "EXPORT_PATH" are not served
+func isPublic(urlPath string) bool {
+	name := strings.SplitN(strings.TrimPrefix(urlPath, "/"), "/", 2)[0]
+
+	for _, fileName := range exporter.RootFiles {
+		if urlPath == "/"+fileName {
+			return true
+		}
+	}
+	for _, dirName := range utils.ExportDirectories {
+		if name == dirName && urlPath != "/"+dirName {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request) {
+	recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+	partner, ok := s.authenticate(r)
+	defer func() {
+		createLog(partner, r, recorder)
+	}()
+
+	if !ok {
+		if s.Auth == "basic" {
+			recorder.Header().Set("WWW-Authenticate", `Basic realm="feeds"`)
+		}
+		http.Error(recorder, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(recorder, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	urlPath := path.Clean("/" + r.URL.Path)
+	if urlPath == "/" {
+		urlPath = "/feed.xml"
+	}
+
+	if !isPublic(urlPath) {
+		http.NotFound(recorder, r)
+		return
+	}
+
+	filePath := filepath.Join(s.Root, filepath.FromSlash(urlPath))
+	contentType := mime.TypeByExtension(filepath.Ext(filePath))
+	if filepath.Ext(filePath) == ".xml" {
+		contentType = "application/xml; charset=utf-8"
+	}
+
+	// feedN.xml.gz written by the exporter is served in place of feedN.xml to the clients
+	// accepting gzip, ranges then apply to the compressed bytes
+	encoding := ""
+	if acceptsGzip(r.Header.Get("Accept-Encoding")) && !strings.HasSuffix(filePath, ".gz") {
+		if _, err := os.Stat(filePath + ".gz"); err == nil {
+			filePath += ".gz"
+			encoding = "gzip"
+		}
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		http.NotFound(recorder, r)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(recorder, r)
+		return
+	}
+
+	header := recorder.Header()
+	header.Set("Vary", "Accept-Encoding")
+	header.Set("ETag", `"`+strconv.FormatInt(info.ModTime().UnixNano(), 16)+"-"+strconv.FormatInt(info.Size(), 16)+etagSuffix(encoding)+`"`)
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	if encoding != "" {
+		header.Set("Content-Encoding", encoding)
+	}
+
+	http.ServeContent(recorder, r, filepath.Base(filePath), info.ModTime(), f)
+}
+
+// acceptsGzip is true when gzip, or * without gzip, is accepted with a q-value above 0,
+// e.g. "gzip;q=0" refuses gzip
+func acceptsGzip(acceptEncoding string) bool {
+	gzipQuality, anyQuality := -1.0, -1.0
+
+	for _, coding := range strings.Split(acceptEncoding, ",") {
+		params := strings.Split(coding, ";")
+		name := strings.ToLower(strings.TrimSpace(params[0]))
+
+		quality := 1.0
+		for _, param := range params[1:] {
+			keyAndValue := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(keyAndValue) == 2 && strings.ToLower(keyAndValue[0]) == "q" {
+				q, err := strconv.ParseFloat(strings.TrimSpace(keyAndValue[1]), 64)
+				if err != nil {
+					q = 0
+				}
+				quality = q
+			}
+		}
+
+		switch name {
+		case "gzip", "x-gzip":
+			gzipQuality = quality
+		case "*":
+			anyQuality = quality
+		}
+	}
+
+	if gzipQuality >= 0 {
+		return gzipQuality > 0
+	}
+	return anyQuality > 0
+}
+
+func etagSuffix(encoding string) string {
+	if encoding == "" {
+		return ""
+	}
+	return "-" + encoding
+}
+
+// authenticate resolves the partner of the request by "SERVE_AUTH" of .env file, "basic"
+// checks the basic auth credentials, "token" checks the token query parameter or bearer token
+// and "none" allows every request
+func (s *Server) authenticate(r *http.Request) (string, bool) {
+	switch s.Auth {
+	case "basic":
+		user, password, ok := r.BasicAuth()
+		if !ok {
+			return "", false
+		}
+
+		for _, partner := range s.Partners {
+			if partner.Name == user && secretEquals(partner.Secret, password) {
+				return partner.Name, true
+			}
+		}
+		return user, false
+	case "token":
+		token := r.URL.Query().Get("token")
+		if bearer := r.Header.Get("Authorization"); strings.HasPrefix(bearer, "Bearer ") {
+			token = strings.TrimPrefix(bearer, "Bearer ")
+		}
+
+		for _, partner := range s.Partners {
+			if token != "" && secretEquals(partner.Secret, token) {
+				return partner.Name, true
+			}
+		}
+		return "", false
+	case "none":
+		return "", true
+	}
+
+	return "", false
+}
+
+func secretEquals(secret string, given string) bool {
+	return subtle.ConstantTimeCompare([]byte(secret), []byte(given)) == 1
+}
+
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+// createLog appends every download with its partner to download-log.txt
+func createLog(partner string, r *http.Request, recorder *responseRecorder) {
+	if partner == "" {
+		partner = "-"
+	}
+
+	mut.Lock()
+	defer mut.Unlock()
+
+	f, err := os.OpenFile("download-log.txt", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Println(err.Error())
+		return
+	}
+	defer f.Close()
+
+	now := time.Now().Local().Format("2006-01-02 15:04:05")
+	output := "[" + now + "] [" + partner + "] [" + strconv.Itoa(recorder.status) + "] [" + strconv.Itoa(recorder.bytes) + "] " + r.RemoteAddr + " - " + r.URL.Path + "\n"
+	if _, err = f.Write([]byte(output)); err != nil {
+		log.Println(err.Error())
+	}
+}
@@ -0,0 +1,197 @@
+package sitemap
+
+import (
+	"database/sql"
+	"encoding/xml"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"bitbucket.org/waseka/waseka-xml-generator/utils"
+)
+
+// maximum number of URLs and uncompressed size of a single sitemap file as per sitemaps.org protocol
+const MAX_URLS = 50000
+const MAX_BYTES = 50 * 1024 * 1024
+
+// room kept in a sitemap file for the XML header and the <urlset> element around the URLs
+const envelopeBytes = 1024
+
+const DIRECTORY = "sitemaps"
+
+var properties []URL
+var agents = make(map[string]URL)
+var mut sync.Mutex
+
+type URLSet struct {
+	XMLName    xml.Name `xml:"urlset"`
+	Xmlns      string   `xml:"xmlns,attr"`
+	XmlnsImage string   `xml:"xmlns:image,attr,omitempty"`
+	URL        []URL    `xml:"url"`
+}
+
+type URL struct {
+	Loc     string  `xml:"loc"`
+	LastMod string  `xml:"lastmod,omitempty"`
+	Images  []Image `xml:"image:image"`
+}
+
+type Image struct {
+	Loc string `xml:"image:loc"`
+}
+
+type SitemapIndex struct {
+	XMLName xml.Name  `xml:"sitemapindex"`
+	Xmlns   string    `xml:"xmlns,attr"`
+	Sitemap []Sitemap `xml:"sitemap"`
+}
+
+type Sitemap struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+// IsEnabled checks "GENERATE_SITEMAP" from .env file
+func IsEnabled() bool {
+	return os.Getenv("GENERATE_SITEMAP") == "true"
+}
+
+// Add collects the listing page and the agent page of a parsed property
+func Add(property utils.Property, propertyCategory string) {
+	lastMod := lastModified(property.PublishedAt)
+
+	var images []Image
+	for _, image := range property.AdvertImages {
+		images = append(images, Image{Loc: image})
+	}
+
+	mut.Lock()
+	defer mut.Unlock()
+
+	properties = append(properties, URL{
+		Loc:     utils.PropertyURL(propertyCategory, property.Id),
+		LastMod: lastMod,
+		Images:  images,
+	})
+
+	// agent page is modified whenever the latest of its listings is published
+	agentURL := utils.CompanyURL(property.BranchName, property.BranchId)
+	if agent, ok := agents[agentURL]; !ok || agent.LastMod < lastMod {
+		agents[agentURL] = URL{Loc: agentURL, LastMod: lastMod}
+	}
+}
+
+// published_at is scanned as "2006-01-02 15:04:05", sitemap only needs the date part
+func lastModified(publishedAt sql.NullString) string {
+	if !publishedAt.Valid || len(publishedAt.String) < 10 {
+		return ""
+	}
+	return publishedAt.String[:10]
+}
+
+// Generate writes sitemap-properties-N.xml, sitemap-agents.xml and the sitemap.xml index
+// to sitemaps directory and clears the collected URLs
+func Generate() {
+	mut.Lock()
+	defer mut.Unlock()
+
+	utils.RemoveExistentContents(DIRECTORY)
+
+	var fileNames []string
+	for i, urls := range splitURLs(properties) {
+		fileName := "sitemap-properties-" + strconv.Itoa(i+1) + ".xml"
+		writeURLSet(fileName, urls, true)
+		fileNames = append(fileNames, fileName)
+	}
+
+	if len(agents) > 0 {
+		var agentURLs []URL
+		for _, agent := range agents {
+			agentURLs = append(agentURLs, agent)
+		}
+		sort.Slice(agentURLs, func(i, j int) bool {
+			return agentURLs[i].Loc < agentURLs[j].Loc
+		})
+
+		fileName := "sitemap-agents.xml"
+		writeURLSet(fileName, agentURLs, false)
+		fileNames = append(fileNames, fileName)
+	}
+
+	if len(fileNames) > 0 {
+		writeIndex(fileNames)
+	}
+
+	properties = nil
+	agents = make(map[string]URL)
+}
+
+// splitURLs splits the URLs into sitemap files of at most MAX_URLS URLs and MAX_BYTES, the
+// image extension of a listing easily takes 1 KB so 50,000 listings go over 50 MB
+func splitURLs(urls []URL) [][]URL {
+	var files [][]URL
+	start := 0
+	size := envelopeBytes
+
+	for i, url := range urls {
+		urlSize := urlBytes(url)
+		if i > start && (i-start >= MAX_URLS || size+urlSize > MAX_BYTES) {
+			files = append(files, urls[start:i])
+			start = i
+			size = envelopeBytes
+		}
+		size += urlSize
+	}
+
+	if start < len(urls) {
+		files = append(files, urls[start:])
+	}
+	return files
+}
+
+// urlBytes is the size of a <url> element as it is indented in the sitemap file, the element
+// marshals as <URL> on its own which has the same length
+func urlBytes(url URL) int {
+	output, err := xml.MarshalIndent(url, "    ", "    ")
+	if err != nil {
+		panic(err.Error())
+	}
+	return len(output) + len("\n")
+}
+
+// Reset clears the URLs collected by a previous run
+func Reset() {
+	mut.Lock()
+	defer mut.Unlock()
+
+	properties = nil
+	agents = make(map[string]URL)
+}
+
+func writeURLSet(fileName string, urls []URL, hasImages bool) {
+	urlSet := URLSet{
+		Xmlns: "http://www.sitemaps.org/schemas/sitemap/0.9",
+		URL:   urls,
+	}
+	if hasImages {
+		urlSet.XmlnsImage = "http://www.google.com/schemas/sitemap-image/1.1"
+	}
+
+	utils.WriteXMLFile(DIRECTORY+"/"+fileName, urlSet)
+}
+
+func writeIndex(fileNames []string) {
+	now := time.Now().Format(time.RFC3339)
+	index := SitemapIndex{Xmlns: "http://www.sitemaps.org/schemas/sitemap/0.9"}
+
+	for _, fileName := range fileNames {
+		index.Sitemap = append(index.Sitemap, Sitemap{
+			Loc:     os.Getenv("APP_URL") + "/" + DIRECTORY + "/" + fileName,
+			LastMod: now,
+		})
+	}
+
+	utils.WriteXMLFile(DIRECTORY+"/sitemap.xml", index)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"bitbucket.org/waseka/waseka-xml-generator/history"
+	"bitbucket.org/waseka/waseka-xml-generator/utils"
+)
+
+// exported feeds older than "STALE_AFTER" of .env file are stale when it is not set
+const DEFAULT_STALE_AFTER = 24 * time.Hour
+
+// status prints the last runs of every category and whether the feeds exported to
+// "EXPORT_PATH" are fresh, it exits with code 1 when a category is stale
+func status(limit int) {
+	store, err := history.NewStore()
+	if err != nil {
+		panic(err.Error())
+	}
+	defer store.Close()
+
+	var categories []string
+	for category := range utils.PropertyTableMap {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+
+	staleAfter := utils.EnvDuration("STALE_AFTER", DEFAULT_STALE_AFTER)
+	exportedAt := exportedFeedTimes(os.Getenv("EXPORT_PATH"))
+	isStale := false
+
+	for _, category := range categories {
+		runs, err := store.Latest(category, limit)
+		if err != nil {
+			panic(err.Error())
+		}
+
+		freshness := "fresh"
+		modTime, ok := exportedAt[category]
+		if !ok {
+			freshness = "not exported"
+			isStale = true
+		} else if time.Since(modTime) > staleAfter {
+			freshness = "stale"
+			isStale = true
+		}
+
+		fmt.Printf("\n%s - %s", category, freshness)
+		if ok {
+			fmt.Printf(", exported at %s", modTime.Format("2006-01-02 15:04:05"))
+		}
+		fmt.Println()
+
+		if len(runs) == 0 {
+			fmt.Println("    no runs recorded")
+		}
+		for _, run := range runs {
+			printRun(run)
+		}
+	}
+
+	if isStale {
+		fmt.Println("\nExported feeds are missing or older than", staleAfter)
+		os.Exit(1)
+	}
+}
+
+func printRun(run history.Run) {
+	result := "ok"
+	if run.Error != "" {
+		result = "error - " + run.Error
+	}
+
+	fmt.Printf("    [%s] %s, took %s, parsed %d, skipped %d, failed %d, %d files, %s, %s\n",
+		run.Id,
+		run.StartedAt.Local().Format("2006-01-02 15:04:05"),
+		run.FinishedAt.Sub(run.StartedAt).Round(time.Second),
+		run.Parsed,
+		run.Skipped,
+		run.Failed,
+		len(run.OutputFiles),
+		run.ExportResult,
+		result,
+	)
+}
+
+// exportedFeedTimes returns the modification time of the newest exported feed of every
+// category, the symlinks of the local releases are followed
+func exportedFeedTimes(exportPath string) map[string]time.Time {
+	times := map[string]time.Time{}
+	if exportPath == "" {
+		return times
+	}
+
+	files, err := ioutil.ReadDir(filepath.Join(exportPath, "feeds"))
+	if err != nil {
+		return times
+	}
+
+	for _, file := range files {
+		category := utils.CategoryByFileName(file.Name())
+		if category == "" || file.IsDir() {
+			continue
+		}
+
+		if file.ModTime().After(times[category]) {
+			times[category] = file.ModTime()
+		}
+	}
+
+	return times
+}
@@ -0,0 +1,79 @@
+package syndication
+
+import (
+	"encoding/xml"
+	"os"
+	"time"
+
+	"bitbucket.org/waseka/waseka-xml-generator/utils"
+)
+
+type AtomFeed struct {
+	XMLName xml.Name    `xml:"feed"`
+	Xmlns   string      `xml:"xmlns,attr"`
+	Id      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  AtomAuthor  `xml:"author"`
+	Link    []AtomLink  `xml:"link"`
+	Entry   []AtomEntry `xml:"entry"`
+}
+
+// AtomAuthor of the feed applies to every entry, RFC 4287 requires an author on the feed
+// or on each of its entries
+type AtomAuthor struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type AtomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type AtomEntry struct {
+	Id        string     `xml:"id"`
+	Title     string     `xml:"title"`
+	Published string     `xml:"published"`
+	Updated   string     `xml:"updated"`
+	Summary   string     `xml:"summary,omitempty"`
+	Link      []AtomLink `xml:"link"`
+}
+
+func writeAtom(propertyCategory string, properties []utils.Property) {
+	fileName := propertyCategory + ".atom.xml"
+	feed := AtomFeed{
+		Xmlns:   "http://www.w3.org/2005/Atom",
+		Id:      fileURL(fileName),
+		Title:   channelTitle(propertyCategory),
+		Updated: time.Now().Format(time.RFC3339),
+		Author:  AtomAuthor{Name: siteName(), URI: os.Getenv("APP_URL")},
+		Link: []AtomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: fileURL(fileName)},
+			{Rel: "alternate", Href: os.Getenv("APP_URL")},
+		},
+	}
+
+	for _, property := range properties {
+		published := publishedTime(property).Format(time.RFC3339)
+		entry := AtomEntry{
+			Id:        utils.PropertyURL(propertyCategory, property.Id),
+			Title:     utils.PropertyTitle(property, propertyCategory),
+			Published: published,
+			Updated:   published,
+			Summary:   property.ShortDescription,
+			Link: []AtomLink{
+				{Rel: "alternate", Href: utils.PropertyURL(propertyCategory, property.Id)},
+			},
+		}
+
+		if property.Thumbnail != "" {
+			entry.Link = append(entry.Link, AtomLink{Rel: "enclosure", Type: imageType(property.Thumbnail), Href: property.Thumbnail})
+		}
+
+		feed.Entry = append(feed.Entry, entry)
+	}
+
+	utils.WriteXMLFile(DIRECTORY+"/"+fileName, feed)
+}
@@ -0,0 +1,59 @@
+package syndication
+
+import (
+	"encoding/xml"
+	"os"
+	"time"
+
+	"bitbucket.org/waseka/waseka-xml-generator/utils"
+)
+
+type RSS struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel RSSChannel `xml:"channel"`
+}
+
+type RSSChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Item          []RSSItem `xml:"item"`
+}
+
+// RSSItem has no enclosure of the thumbnail, RSS requires its length which is not known
+// without downloading it
+type RSSItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	Guid        string `xml:"guid"`
+	PubDate     string `xml:"pubDate"`
+	Description string `xml:"description,omitempty"`
+}
+
+func writeRSS(propertyCategory string, properties []utils.Property) {
+	rss := RSS{
+		Version: "2.0",
+		Channel: RSSChannel{
+			Title:         channelTitle(propertyCategory),
+			Link:          os.Getenv("APP_URL"),
+			Description:   channelTitle(propertyCategory),
+			LastBuildDate: time.Now().Format(time.RFC1123Z),
+		},
+	}
+
+	for _, property := range properties {
+		item := RSSItem{
+			Title:       utils.PropertyTitle(property, propertyCategory),
+			Link:        utils.PropertyURL(propertyCategory, property.Id),
+			Guid:        utils.PropertyURL(propertyCategory, property.Id),
+			PubDate:     publishedTime(property).Format(time.RFC1123Z),
+			Description: property.ShortDescription,
+		}
+
+		rss.Channel.Item = append(rss.Channel.Item, item)
+	}
+
+	utils.WriteXMLFile(DIRECTORY+"/"+propertyCategory+".rss.xml", rss)
+}
@@ -0,0 +1,120 @@
+package syndication
+
+import (
+	"mime"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bitbucket.org/waseka/waseka-xml-generator/utils"
+)
+
+const DIRECTORY = "syndication"
+
+// number of newest listings per category when "SYNDICATION_LIMIT" is not set in .env file
+const DEFAULT_LIMIT = 50
+
+var listings = make(map[string][]utils.Property)
+var mut sync.Mutex
+
+// IsEnabled checks "GENERATE_SYNDICATION" from .env file
+func IsEnabled() bool {
+	return os.Getenv("GENERATE_SYNDICATION") == "true"
+}
+
+func limit() int {
+	value, err := strconv.Atoi(os.Getenv("SYNDICATION_LIMIT"))
+	if err != nil || value <= 0 {
+		return DEFAULT_LIMIT
+	}
+	return value
+}
+
+// Add collects a parsed listing, only the newest listings of each category are kept
+func Add(property utils.Property, propertyCategory string) {
+	mut.Lock()
+	defer mut.Unlock()
+
+	listings[propertyCategory] = append(listings[propertyCategory], property)
+
+	// trim once in a while rather than keeping every listing of the category in memory
+	if len(listings[propertyCategory]) >= 2*limit() {
+		listings[propertyCategory] = newest(listings[propertyCategory])
+	}
+}
+
+func newest(properties []utils.Property) []utils.Property {
+	// published_at is scanned as "2006-01-02 15:04:05" which sorts as text
+	sort.SliceStable(properties, func(i, j int) bool {
+		return properties[i].PublishedAt.String > properties[j].PublishedAt.String
+	})
+
+	if len(properties) > limit() {
+		properties = properties[:limit()]
+	}
+	return properties
+}
+
+// Generate writes an Atom and an RSS 2.0 feed per category to syndication directory
+// and clears the collected listings
+func Generate() {
+	mut.Lock()
+	defer mut.Unlock()
+
+	utils.RemoveExistentContents(DIRECTORY)
+
+	for propertyCategory, properties := range listings {
+		properties = newest(properties)
+
+		writeAtom(propertyCategory, properties)
+		writeRSS(propertyCategory, properties)
+	}
+
+	listings = make(map[string][]utils.Property)
+}
+
+// Reset clears the listings collected by a previous run
+func Reset() {
+	mut.Lock()
+	defer mut.Unlock()
+
+	listings = make(map[string][]utils.Property)
+}
+
+func channelTitle(propertyCategory string) string {
+	// e.g. "Latest residential properties for sale"
+	return "Latest " + strings.Split(propertyCategory, "-")[0] + " properties " + utils.SaleOrLet(propertyCategory)
+}
+
+// siteName is the host of "APP_URL" of .env file, e.g. example.com, the author of the feeds
+func siteName() string {
+	appURL := os.Getenv("APP_URL")
+	if u, err := url.Parse(appURL); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return appURL
+}
+
+func publishedTime(property utils.Property) time.Time {
+	published, err := time.ParseInLocation("2006-01-02 15:04:05", property.PublishedAt.String, time.Local)
+	if err != nil {
+		return time.Now()
+	}
+	return published
+}
+
+func imageType(imageURL string) string {
+	if imageType := mime.TypeByExtension(filepath.Ext(imageURL)); imageType != "" {
+		return imageType
+	}
+	return "image/jpeg"
+}
+
+func fileURL(fileName string) string {
+	return os.Getenv("APP_URL") + "/" + DIRECTORY + "/" + fileName
+}
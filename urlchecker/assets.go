@@ -0,0 +1,175 @@
+package urlchecker
+
+import (
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"bitbucket.org/waseka/waseka-xml-generator/logger"
+)
+
+// kinds of the checked URLs of an advert
+const (
+	KIND_URL       = "url"
+	KIND_THUMBNAIL = "thumbnail"
+	KIND_IMAGE     = "image"
+	KIND_COMPANY   = "company"
+	// a malformed feed or an advert which can not be decoded
+	KIND_FEED = "feed"
+)
+
+// minimum dimensions of "URL_CHECK_IMAGE_MIN_WIDTH" and "URL_CHECK_IMAGE_MIN_HEIGHT" of
+// .env file, 0 is not checked
+var minWidth int
+var minHeight int
+
+func isImage(kind string) bool {
+	return kind == KIND_THUMBNAIL || kind == KIND_IMAGE
+}
+
+func hasMinDimensions() bool {
+	return minWidth > 0 || minHeight > 0
+}
+
+// imageDimensions decodes only the header of a JPEG, PNG or GIF image, the dimensions of
+// other formats are unknown and returned as 0
+func imageDimensions(body io.Reader) (int, int) {
+	config, _, err := image.DecodeConfig(body)
+	if err != nil {
+		return 0, 0
+	}
+	return config.Width, config.Height
+}
+
+// validateImage returns why an image is rejected or an empty string
+func validateImage(result Result) string {
+	contentType := strings.ToLower(result.ContentType)
+	if !strings.HasPrefix(contentType, "image/") {
+		return fmt.Sprintf("content type %q is not an image", result.ContentType)
+	}
+
+	if result.Width == 0 || result.Height == 0 {
+		return ""
+	}
+	if result.Width < minWidth || result.Height < minHeight {
+		return fmt.Sprintf("image of %dx%d is smaller than %dx%d", result.Width, result.Height, minWidth, minHeight)
+	}
+	return ""
+}
+
+// cachedCheck is the check of a URL shared by several adverts, e.g. the company URL of a
+// branch, which is requested only once. Only the outcome is kept for the other adverts, not
+// the redirect chain, so the cache stays small on a check of millions of URLs
+type cachedCheck struct {
+	done        chan struct{}
+	statusCode  int
+	contentType string
+	flags       []string
+	err         string
+	skipped     bool
+}
+
+var checked map[string]*cachedCheck
+var checkedMut sync.Mutex
+
+func checkOnce(c check) Result {
+	key := c.kind + " " + c.url
+
+	checkedMut.Lock()
+	cached, ok := checked[key]
+	if !ok {
+		cached = &cachedCheck{done: make(chan struct{})}
+		checked[key] = cached
+	}
+	checkedMut.Unlock()
+
+	if !ok {
+		var result Result
+		if entry, fresh := progress.fresh(c.kind, c.url); fresh {
+			c.feedLog.Debug("URL skipped", "request", c.requestNumber, "advert_id", c.advertId, "kind", c.kind, "checked_at", entry.CheckedAt.Format(time.RFC3339), "url", c.url)
+			result = Result{Category: c.category, Feed: c.feed, AdvertId: c.advertId, Line: c.line, Kind: c.kind, URL: c.url, StatusCode: entry.StatusCode, Skipped: true}
+		} else {
+			result = sendRequest(c)
+			progress.record(result)
+		}
+
+		cached.statusCode = result.StatusCode
+		cached.contentType = result.ContentType
+		cached.flags = result.Flags
+		cached.err = result.Error
+		cached.skipped = result.Skipped
+		close(cached.done)
+		return result
+	}
+
+	<-cached.done
+	return Result{
+		Category:    c.category,
+		Feed:        c.feed,
+		AdvertId:    c.advertId,
+		Line:        c.line,
+		Kind:        c.kind,
+		URL:         c.url,
+		StatusCode:  cached.statusCode,
+		ContentType: cached.contentType,
+		Flags:       cached.flags,
+		Error:       cached.err,
+		Skipped:     cached.skipped,
+	}
+}
+
+// reportBrokenAdverts writes an entry per advert listing its broken assets, e.g.
+// broken="thumbnail,image x2"
+func reportBrokenAdverts(results []Result) {
+	type advertKey struct {
+		feed     string
+		advertId int
+	}
+
+	broken := map[advertKey]map[string]int{}
+	categories := map[advertKey]string{}
+	var keys []advertKey
+
+	for _, result := range results {
+		// invalid feeds are reported with their position when they are read
+		if !result.Failed() || result.Kind == KIND_FEED {
+			continue
+		}
+
+		key := advertKey{result.Feed, result.AdvertId}
+		if _, ok := broken[key]; !ok {
+			broken[key] = map[string]int{}
+			categories[key] = result.Category
+			keys = append(keys, key)
+		}
+		broken[key][result.Kind]++
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].feed != keys[j].feed {
+			return keys[i].feed < keys[j].feed
+		}
+		return keys[i].advertId < keys[j].advertId
+	})
+
+	for _, key := range keys {
+		var kinds []string
+		for _, kind := range []string{KIND_URL, KIND_THUMBNAIL, KIND_IMAGE, KIND_COMPANY} {
+			count := broken[key][kind]
+			if count == 1 {
+				kinds = append(kinds, kind)
+			} else if count > 1 {
+				kinds = append(kinds, fmt.Sprintf("%s x%d", kind, count))
+			}
+		}
+
+		logger.Warn("Advert has broken URLs", "category", categories[key], "feed", key.feed, "advert_id", key.advertId, "broken", strings.Join(kinds, ","))
+	}
+}
@@ -0,0 +1,92 @@
+package urlchecker
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"bitbucket.org/waseka/waseka-xml-generator/utils"
+)
+
+// Result of the check of an advert URL or one of its assets
+type Result struct {
+	Category string `json:"category"`
+	Feed     string `json:"feed"`
+	AdvertId int    `json:"advert_id"`
+	// line of the advert in the feed
+	Line        int    `json:"line,omitempty"`
+	Kind        string `json:"kind"`
+	URL         string `json:"url"`
+	StatusCode  int    `json:"status_code"`
+	ContentType string `json:"content_type,omitempty"`
+	// redirects followed to the final URL, empty when the URL was not redirected
+	Redirects []Redirect `json:"redirects,omitempty"`
+	FinalURL  string     `json:"final_url,omitempty"`
+	// dimensions of an image, only read when minimum dimensions are configured
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+	// error of the last request when no response was received, e.g. a DNS failure,
+	// or the reason an image was rejected
+	Error string `json:"error,omitempty"`
+	// flags of an advert page which is not the listing, e.g. soft_404
+	Flags    []string `json:"flags,omitempty"`
+	Attempts int      `json:"attempts"`
+	// the URL was verified successfully by a previous check within the freshness window
+	// and is not requested again, see the state file
+	Skipped  bool          `json:"skipped,omitempty"`
+	Duration time.Duration `json:"-"`
+}
+
+// Redirect is a redirect response of the redirect chain
+type Redirect struct {
+	URL        string `json:"url"`
+	StatusCode int    `json:"status_code"`
+	Location   string `json:"location"`
+}
+
+// Failed is true for a transport error, a status code other than 200 or a flagged page
+func (r Result) Failed() bool {
+	return r.Error != "" || r.StatusCode != http.StatusOK || len(r.Flags) > 0
+}
+
+var client *http.Client
+var retries int
+var backoff time.Duration
+var globalLimiter *limiter
+var hostLimiters *hostLimiter
+
+// newClient keeps a connection per worker to every host, every request times out after
+// "URL_CHECK_TIMEOUT" of .env file, defaults to 30s
+func newClient(workers int) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = workers
+
+	return &http.Client{
+		Timeout:   utils.EnvDuration("URL_CHECK_TIMEOUT", 30*time.Second),
+		Transport: transport,
+	}
+}
+
+// redirectChain returns the redirect responses which led to the response, the first first
+func redirectChain(res *http.Response) []Redirect {
+	var chain []Redirect
+	for req := res.Request; req != nil && req.Response != nil; req = req.Response.Request {
+		chain = append([]Redirect{{
+			URL:        req.Response.Request.URL.String(),
+			StatusCode: req.Response.StatusCode,
+			Location:   req.URL.String(),
+		}}, chain...)
+	}
+	return chain
+}
+
+// isRetryable is true for timeouts and server errors, other transport errors and status
+// codes are final, as is 501 of a server which does not support HEAD
+func isRetryable(res *http.Response, err error) bool {
+	if err != nil {
+		var netErr net.Error
+		return errors.As(err, &netErr) && netErr.Timeout()
+	}
+	return res.StatusCode >= http.StatusInternalServerError && res.StatusCode != http.StatusNotImplemented
+}
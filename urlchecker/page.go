@@ -0,0 +1,162 @@
+package urlchecker
+
+import (
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// flags of an advert page which responded with 200 but is not the listing
+const (
+	FLAG_REDIRECT_OFF_LISTING = "redirect_off_listing"
+	FLAG_SOFT_404             = "soft_404"
+	FLAG_CANONICAL_MISMATCH   = "canonical_mismatch"
+)
+
+// bytes of an advert page searched for soft-404 markers and the canonical URL
+const MAX_PAGE_BYTES = 1024 * 1024
+
+var linkTagPattern = regexp.MustCompile(`(?i)<link\s[^>]*>`)
+var canonicalPattern = regexp.MustCompile(`(?i)\srel\s*=\s*["']?canonical["'\s>/]`)
+var hrefPattern = regexp.MustCompile(`(?i)\shref\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+
+// soft404Markers of "URL_CHECK_SOFT_404_MARKERS" of .env file, a comma separated list of
+// texts of the "property not found" page, matched case insensitively
+func soft404Markers() []string {
+	var markers []string
+	for _, marker := range strings.Split(os.Getenv("URL_CHECK_SOFT_404_MARKERS"), ",") {
+		marker = strings.ToLower(strings.TrimSpace(marker))
+		if marker != "" {
+			markers = append(markers, marker)
+		}
+	}
+	return markers
+}
+
+// isCanonicalChecked is "URL_CHECK_CANONICAL" of .env file
+func isCanonicalChecked() bool {
+	return os.Getenv("URL_CHECK_CANONICAL") == "true"
+}
+
+func isPageInspected() bool {
+	return len(soft404Markers()) > 0 || isCanonicalChecked()
+}
+
+var idPattern = regexp.MustCompile(`[0-9]+`)
+
+// normalizedPath is the unescaped, cleaned and lower case path of the URL without its
+// trailing slash, e.g. /Property//42/ is /property/42
+func normalizedPath(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	p := path.Clean("/" + strings.ToLower(u.Path))
+	return strings.TrimRight(p, "/")
+}
+
+// listingId is the first number of the last segment of a path, e.g. 42 of
+// /property/42-2-bed-flat, empty when it has none
+func listingId(p string) string {
+	return idPattern.FindString(path.Base(p))
+}
+
+// isListingPage is true when the page is the listing, its path is the listing path, the
+// listing path with a slug appended, e.g. /property/42 and /property/42/2-bed-flat, or a
+// rewritten slug next to the listing path which starts or ends with the id of the advert or
+// the first id of the listing path, e.g. /property/42-old-slug and /property/42-new-slug.
+// A number elsewhere, e.g. /search/page/2 or /agents/branch-42, is not the listing
+func isListingPage(listingURL string, pageURL string, advertId int) bool {
+	listing := normalizedPath(listingURL)
+	page := normalizedPath(pageURL)
+
+	if page == listing || strings.HasPrefix(page, listing+"/") {
+		return true
+	}
+	if path.Dir(page) != path.Dir(listing) {
+		return false
+	}
+
+	var ids []string
+	if advertId > 0 {
+		ids = append(ids, strconv.Itoa(advertId))
+	}
+	if id := listingId(listing); id != "" {
+		ids = append(ids, id)
+	}
+
+	pageIds := idPattern.FindAllString(path.Base(page), -1)
+	if len(pageIds) == 0 {
+		return false
+	}
+	for _, id := range ids {
+		if pageIds[0] == id || pageIds[len(pageIds)-1] == id {
+			return true
+		}
+	}
+	return false
+}
+
+// redirectFlags flags a redirect away from the listing, e.g. to the homepage or the search
+// page, a redirect to another scheme or host, a trailing slash or a rewritten slug is fine
+func redirectFlags(result Result) []string {
+	if result.FinalURL == "" || isListingPage(result.URL, result.FinalURL, result.AdvertId) {
+		return nil
+	}
+	return []string{FLAG_REDIRECT_OFF_LISTING}
+}
+
+// pageFlags searches the advert page for a soft-404 marker and compares its canonical URL
+// with the listing, see isListingPage
+func pageFlags(listingURL string, advertId int, pageURL *url.URL, body io.Reader) []string {
+	page, err := ioutil.ReadAll(io.LimitReader(body, MAX_PAGE_BYTES))
+	if err != nil {
+		return nil
+	}
+
+	var flags []string
+	lowerPage := strings.ToLower(string(page))
+	for _, marker := range soft404Markers() {
+		if strings.Contains(lowerPage, marker) {
+			flags = append(flags, FLAG_SOFT_404)
+			break
+		}
+	}
+
+	if isCanonicalChecked() {
+		canonical := canonicalURL(string(page))
+		if canonical != "" {
+			if u, err := pageURL.Parse(canonical); err == nil && !isListingPage(listingURL, u.String(), advertId) {
+				flags = append(flags, FLAG_CANONICAL_MISMATCH)
+			}
+		}
+	}
+
+	return flags
+}
+
+// canonicalURL returns the href of <link rel="canonical">, empty when the page has none
+func canonicalURL(page string) string {
+	for _, tag := range linkTagPattern.FindAllString(page, -1) {
+		if !canonicalPattern.MatchString(tag + " ") {
+			continue
+		}
+
+		match := hrefPattern.FindStringSubmatch(tag)
+		if match == nil {
+			return ""
+		}
+		for _, href := range match[1:] {
+			if href != "" {
+				return strings.TrimSpace(href)
+			}
+		}
+	}
+	return ""
+}
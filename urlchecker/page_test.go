@@ -0,0 +1,59 @@
+package urlchecker
+
+import "testing"
+
+func TestIsListingPage(t *testing.T) {
+	tests := []struct {
+		listing  string
+		page     string
+		advertId int
+		want     bool
+	}{
+		{"https://example.com/property/42", "https://example.com/property/42", 0, true},
+		{"https://example.com/property/42", "https://example.com/property/42/", 0, true},
+		{"http://example.com/property/42", "https://www.example.com/property/42", 0, true},
+		{"https://example.com/Property/42", "https://example.com/property//42", 0, true},
+		{"https://example.com/property/42", "https://example.com/property/42/2-bed-flat", 0, true},
+		{"https://example.com/property/42-old-slug", "https://example.com/property/42-new-slug", 0, true},
+		{"https://example.com/property/old-slug", "https://example.com/property/2-bed-flat-4711", 4711, true},
+		{"https://example.com/property/42", "https://example.com/", 42, false},
+		{"https://example.com/property/old-slug", "https://example.com/flats/2-bed-flat-4711", 4711, false},
+		{"https://example.com/single-property/residential-for-sale/2", "https://example.com/search/page/2", 2, false},
+		{"https://example.com/single-property/residential-for-sale/2", "https://example.com/single-property/residential-for-sale", 2, false},
+		{"https://example.com/property/42", "https://example.com/agents/acme-lettings-42", 42, false},
+		{"https://example.com/property/42", "https://example.com/agents/42/acme-lettings", 42, false},
+		{"https://example.com/property/2", "https://example.com/property/3-bed-2-bath-4711", 2, false},
+		{"https://example.com/property/42", "https://example.com/property", 42, false},
+		{"https://example.com/property/42", "https://example.com/search?id=42", 42, false},
+		{"https://example.com/property/old-slug", "https://example.com/property/new-slug", 0, false},
+	}
+
+	for _, test := range tests {
+		if got := isListingPage(test.listing, test.page, test.advertId); got != test.want {
+			t.Errorf("isListingPage(%q, %q, %d) = %v, want %v", test.listing, test.page, test.advertId, got, test.want)
+		}
+	}
+}
+
+func TestRedirectFlags(t *testing.T) {
+	result := Result{AdvertId: 42, URL: "https://example.com/property/42", FinalURL: "https://example.com/property/42/"}
+	if flags := redirectFlags(result); len(flags) != 0 {
+		t.Errorf("trailing slash redirect flagged %v", flags)
+	}
+
+	result.FinalURL = "https://example.com/"
+	if flags := redirectFlags(result); len(flags) != 1 || flags[0] != FLAG_REDIRECT_OFF_LISTING {
+		t.Errorf("homepage redirect flagged %v, want %s", flags, FLAG_REDIRECT_OFF_LISTING)
+	}
+
+	// a dead listing redirecting to the agent page whose branch id is the advert id
+	result.FinalURL = "https://example.com/agents/acme-lettings-42"
+	if flags := redirectFlags(result); len(flags) != 1 || flags[0] != FLAG_REDIRECT_OFF_LISTING {
+		t.Errorf("agent page redirect flagged %v, want %s", flags, FLAG_REDIRECT_OFF_LISTING)
+	}
+
+	result.FinalURL = ""
+	if flags := redirectFlags(result); len(flags) != 0 {
+		t.Errorf("not redirected URL flagged %v", flags)
+	}
+}
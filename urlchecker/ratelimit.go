@@ -0,0 +1,68 @@
+package urlchecker
+
+import (
+	"net/url"
+	"sync"
+	"time"
+)
+
+// limiter spaces the requests evenly to at most rate requests per second, a rate of 0 is
+// unlimited
+type limiter struct {
+	interval time.Duration
+	next     time.Time
+	mut      sync.Mutex
+}
+
+func newLimiter(rate float64) *limiter {
+	if rate <= 0 {
+		return &limiter{}
+	}
+	return &limiter{interval: time.Duration(float64(time.Second) / rate)}
+}
+
+// Wait blocks until the next request is allowed
+func (l *limiter) Wait() {
+	if l.interval == 0 {
+		return
+	}
+
+	l.mut.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mut.Unlock()
+
+	time.Sleep(wait)
+}
+
+// hostLimiter keeps a limiter per host of the checked URLs
+type hostLimiter struct {
+	rate     float64
+	limiters map[string]*limiter
+	mut      sync.Mutex
+}
+
+func newHostLimiter(rate float64) *hostLimiter {
+	return &hostLimiter{rate: rate, limiters: map[string]*limiter{}}
+}
+
+func (h *hostLimiter) Wait(rawURL string) {
+	host := rawURL
+	if u, err := url.Parse(rawURL); err == nil {
+		host = u.Host
+	}
+
+	h.mut.Lock()
+	l, ok := h.limiters[host]
+	if !ok {
+		l = newLimiter(h.rate)
+		h.limiters[host] = l
+	}
+	h.mut.Unlock()
+
+	l.Wait()
+}
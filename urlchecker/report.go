@@ -0,0 +1,315 @@
+package urlchecker
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"bitbucket.org/waseka/waseka-xml-generator/logger"
+)
+
+const DEFAULT_REPORT_DIR = "reports"
+
+// jsonReport of url-check.json, the latency of every URL is in milliseconds
+type jsonReport struct {
+	GeneratedAt string       `json:"generated_at"`
+	Checked     int          `json:"checked"`
+	Failed      int          `json:"failed"`
+	Results     []jsonResult `json:"results"`
+}
+
+type jsonResult struct {
+	Result
+	LatencyMs int64 `json:"latency_ms"`
+	Failed    bool  `json:"failed"`
+}
+
+type junitTestSuites struct {
+	XMLName    xml.Name         `xml:"testsuites"`
+	Tests      int              `xml:"tests,attr"`
+	Failures   int              `xml:"failures,attr"`
+	TestSuites []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Time      string          `xml:"time,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// categoryReport is the summary of a category of the HTML report
+type categoryReport struct {
+	Category    string
+	GeneratedAt string
+	Checked     int
+	Failed      int
+	Kinds       []kindSummary
+	Failures    []Result
+}
+
+type kindSummary struct {
+	Kind    string
+	Checked int
+	Failed  int
+}
+
+// writeReports writes the reports of "URL_CHECK_REPORTS" of .env file, a comma separated
+// list of json, junit and html (default every report), to "URL_CHECK_REPORT_DIR", defaults
+// to reports. The reports of a check replace the reports of the previous check
+func writeReports(results []Result) {
+	value := os.Getenv("URL_CHECK_REPORTS")
+	if value == "" {
+		value = "json,junit,html"
+	}
+	dirName := os.Getenv("URL_CHECK_REPORT_DIR")
+	if dirName == "" {
+		dirName = DEFAULT_REPORT_DIR
+	}
+
+	if err := os.MkdirAll(dirName, 0777); err != nil {
+		logger.Error("Unable to write URL check reports", "error", err)
+		return
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Feed != b.Feed {
+			return a.Feed < b.Feed
+		}
+		if a.AdvertId != b.AdvertId {
+			return a.AdvertId < b.AdvertId
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.URL < b.URL
+	})
+
+	for _, report := range strings.Split(value, ",") {
+		var err error
+		switch strings.TrimSpace(report) {
+		case "json":
+			err = writeJSONReport(filepath.Join(dirName, "url-check.json"), results)
+		case "junit":
+			err = writeJUnitReport(filepath.Join(dirName, "url-check.junit.xml"), results)
+		case "html":
+			err = writeHTMLReports(dirName, results)
+		default:
+			err = fmt.Errorf("unknown report %q", report)
+		}
+
+		if err != nil {
+			logger.Error("Unable to write URL check report", "report", report, "error", err)
+		}
+	}
+}
+
+func countFailed(results []Result) int {
+	failed := 0
+	for _, result := range results {
+		if result.Failed() {
+			failed++
+		}
+	}
+	return failed
+}
+
+// writeFile writes to a temporary file first, so a report is always complete
+func writeFile(filePath string, output []byte) error {
+	tmpFilePath := filePath + ".tmp"
+	if err := os.WriteFile(tmpFilePath, output, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFilePath, filePath)
+}
+
+func writeJSONReport(filePath string, results []Result) error {
+	report := jsonReport{
+		GeneratedAt: time.Now().Format(time.RFC3339),
+		Checked:     len(results),
+		Failed:      countFailed(results),
+		Results:     []jsonResult{},
+	}
+
+	for _, result := range results {
+		report.Results = append(report.Results, jsonResult{
+			Result:    result,
+			LatencyMs: result.Duration.Milliseconds(),
+			Failed:    result.Failed(),
+		})
+	}
+
+	output, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(filePath, output)
+}
+
+// failureMessage describes why a check failed for the JUnit and HTML reports
+func failureMessage(result Result) string {
+	if result.Error != "" {
+		return result.Error
+	}
+	if result.StatusCode == http.StatusOK && len(result.Flags) > 0 {
+		return strings.Join(result.Flags, ", ")
+	}
+	return fmt.Sprintf("status code %d", result.StatusCode)
+}
+
+// writeJUnitReport writes a test suite per category with a test case per checked URL
+func writeJUnitReport(filePath string, results []Result) error {
+	report := junitTestSuites{Tests: len(results), Failures: countFailed(results)}
+
+	for _, category := range resultCategories(results) {
+		suite := junitTestSuite{Name: category}
+		var duration time.Duration
+
+		for _, result := range results {
+			if result.Category != category {
+				continue
+			}
+
+			testCase := junitTestCase{
+				ClassName: category + "." + strings.TrimSuffix(result.Feed, ".xml"),
+				Name:      fmt.Sprintf("advert %d %s %s", result.AdvertId, result.Kind, result.URL),
+				Time:      fmt.Sprintf("%.3f", result.Duration.Seconds()),
+			}
+			if result.Failed() {
+				testCase.Failure = &junitFailure{
+					Message: failureMessage(result),
+					Type:    result.Kind,
+					Text:    fmt.Sprintf("%s after %d attempts", result.URL, result.Attempts),
+				}
+				suite.Failures++
+			}
+
+			suite.Tests++
+			duration += result.Duration
+			suite.TestCases = append(suite.TestCases, testCase)
+		}
+
+		suite.Time = fmt.Sprintf("%.3f", duration.Seconds())
+		report.TestSuites = append(report.TestSuites, suite)
+	}
+
+	output, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(filePath, append([]byte(xml.Header), output...))
+}
+
+func resultCategories(results []Result) []string {
+	seen := map[string]bool{}
+	var categories []string
+	for _, result := range results {
+		if !seen[result.Category] {
+			seen[result.Category] = true
+			categories = append(categories, result.Category)
+		}
+	}
+	sort.Strings(categories)
+	return categories
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"failureMessage": failureMessage,
+	"latency": func(d time.Duration) string {
+		return d.Round(time.Millisecond).String()
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>URL check - {{.Category}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+.failed { color: #b00; }
+</style>
+</head>
+<body>
+<h1>URL check - {{.Category}}</h1>
+<p>Generated at {{.GeneratedAt}}, {{.Checked}} URLs checked, <span class="failed">{{.Failed}} failed</span></p>
+<table>
+<tr><th>Kind</th><th>Checked</th><th>Failed</th></tr>
+{{range .Kinds}}<tr><td>{{.Kind}}</td><td>{{.Checked}}</td><td>{{.Failed}}</td></tr>
+{{end}}</table>
+{{if .Failures}}<h2>Failed URLs</h2>
+<table>
+<tr><th>Advert</th><th>Kind</th><th>Status</th><th>Failure</th><th>Latency</th><th>URL</th><th>Redirects</th></tr>
+{{range .Failures}}<tr><td>{{.AdvertId}}</td><td>{{.Kind}}</td><td>{{.StatusCode}}</td><td class="failed">{{failureMessage .}}</td><td>{{latency .Duration}}</td><td><a href="{{.URL}}">{{.URL}}</a></td><td>{{range .Redirects}}{{.StatusCode}} {{.URL}}<br>{{end}}{{.FinalURL}}</td></tr>
+{{end}}</table>
+{{end}}</body>
+</html>
+`))
+
+// writeHTMLReports writes url-check-<category>.html with the summary and the failed URLs of
+// every category
+func writeHTMLReports(dirName string, results []Result) error {
+	for _, category := range resultCategories(results) {
+		report := categoryReport{Category: category, GeneratedAt: time.Now().Format("2006-01-02 15:04:05")}
+		kinds := map[string]*kindSummary{}
+
+		for _, result := range results {
+			if result.Category != category {
+				continue
+			}
+
+			summary, ok := kinds[result.Kind]
+			if !ok {
+				summary = &kindSummary{Kind: result.Kind}
+				kinds[result.Kind] = summary
+			}
+
+			report.Checked++
+			summary.Checked++
+			if result.Failed() {
+				report.Failed++
+				summary.Failed++
+				report.Failures = append(report.Failures, result)
+			}
+		}
+
+		for _, kind := range []string{KIND_FEED, KIND_URL, KIND_THUMBNAIL, KIND_IMAGE, KIND_COMPANY} {
+			if summary, ok := kinds[kind]; ok {
+				report.Kinds = append(report.Kinds, *summary)
+			}
+		}
+
+		var output strings.Builder
+		if err := htmlTemplate.Execute(&output, report); err != nil {
+			return err
+		}
+
+		if err := writeFile(filepath.Join(dirName, "url-check-"+category+".html"), []byte(output.String())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,167 @@
+package urlchecker
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"bitbucket.org/waseka/waseka-xml-generator/logger"
+	"bitbucket.org/waseka/waseka-xml-generator/utils"
+)
+
+const DEFAULT_STATE_FILE = "url-check-state.jsonl"
+
+// stateEntry is the last check of a URL, a JSON line of the state file
+type stateEntry struct {
+	Kind       string    `json:"kind"`
+	URL        string    `json:"url"`
+	StatusCode int       `json:"status_code"`
+	Failed     bool      `json:"failed"`
+	CheckedAt  time.Time `json:"checked_at"`
+	// settings the check depended on, see checkParams
+	Params string `json:"params,omitempty"`
+}
+
+// state is the progress of a URL check, a line is appended to the state file as soon as a
+// URL is checked so an interrupted check can be resumed
+type state struct {
+	file      *os.File
+	mut       sync.Mutex
+	entries   map[string]stateEntry
+	freshness time.Duration
+}
+
+var progress *state
+
+func stateKey(kind string, url string) string {
+	return kind + " " + url
+}
+
+// checkParams are the settings deciding whether a URL of the kind passes, a URL verified
+// with other settings, e.g. smaller minimum image dimensions, is checked again
+func checkParams(kind string) string {
+	if isImage(kind) {
+		return fmt.Sprintf("min_width=%d,min_height=%d", minWidth, minHeight)
+	}
+	if kind == KIND_URL {
+		return fmt.Sprintf("soft_404_markers=%s,canonical=%t", strings.Join(soft404Markers(), "|"), isCanonicalChecked())
+	}
+	return ""
+}
+
+// openState opens "URL_CHECK_STATE_FILE" of .env file, defaults to url-check-state.jsonl. A
+// check started over empties the state file, a resumed check keeps the URLs checked
+// successfully within "URL_CHECK_FRESHNESS" (default 24h) and compacts the state file to them
+func openState(resume bool) *state {
+	filePath := os.Getenv("URL_CHECK_STATE_FILE")
+	if filePath == "" {
+		filePath = DEFAULT_STATE_FILE
+	}
+
+	return newState(filePath, utils.EnvDuration("URL_CHECK_FRESHNESS", 24*time.Hour), resume)
+}
+
+func newState(filePath string, freshness time.Duration, resume bool) *state {
+	s := &state{entries: map[string]stateEntry{}, freshness: freshness}
+
+	if !resume {
+		utils.EmptyFile(filePath)
+	} else {
+		s.load(filePath)
+		if err := s.compact(filePath); err != nil {
+			panic(err.Error())
+		}
+		logger.Info("URL check resumed", "state_file", filePath, "fresh_urls", len(s.entries), "freshness", s.freshness.String())
+	}
+
+	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		panic(err.Error())
+	}
+	s.file = file
+
+	return s
+}
+
+// load keeps the last successful check of every URL within the freshness window, a line
+// cut off by an interrupted check is ignored
+func (s *state) load(filePath string) {
+	file, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		panic(err.Error())
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry stateEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+
+		key := stateKey(entry.Kind, entry.URL)
+		if entry.Failed || time.Since(entry.CheckedAt) > s.freshness {
+			delete(s.entries, key)
+			continue
+		}
+		s.entries[key] = entry
+	}
+	if err := scanner.Err(); err != nil {
+		panic(err.Error())
+	}
+}
+
+func (s *state) compact(filePath string) error {
+	var output []byte
+	for _, entry := range s.entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		output = append(append(output, line...), '\n')
+	}
+	return writeFile(filePath, output)
+}
+
+// fresh returns the last check of a URL verified successfully within the freshness window
+// with the current settings
+func (s *state) fresh(kind string, url string) (stateEntry, bool) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	entry, ok := s.entries[stateKey(kind, url)]
+	if !ok || time.Since(entry.CheckedAt) > s.freshness || entry.Params != checkParams(kind) {
+		return entry, false
+	}
+	return entry, true
+}
+
+// record appends the check of a URL to the state file
+func (s *state) record(result Result) {
+	entry := stateEntry{Kind: result.Kind, URL: result.URL, StatusCode: result.StatusCode, Failed: result.Failed(), CheckedAt: time.Now(), Params: checkParams(result.Kind)}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if !entry.Failed {
+		s.entries[stateKey(entry.Kind, entry.URL)] = entry
+	}
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		logger.Error("Unable to write URL check state", "error", err)
+	}
+}
+
+func (s *state) Close() {
+	s.file.Close()
+}
@@ -0,0 +1,194 @@
+package urlchecker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// the entries of the checks go to stderr instead of log.txt of the package directory
+	os.Setenv("LOG_SINKS", "stderr")
+	os.Exit(m.Run())
+}
+
+func writeState(t *testing.T, filePath string, entries []stateEntry, extra string) {
+	t.Helper()
+
+	var output []byte
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		output = append(append(output, line...), '\n')
+	}
+	output = append(output, extra...)
+
+	if err := ioutil.WriteFile(filePath, output, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readState(t *testing.T, filePath string) []stateEntry {
+	t.Helper()
+
+	output, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entries []stateEntry
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry stateEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("invalid state line %q: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestStateResume(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "state.jsonl")
+	now := time.Now()
+
+	writeState(t, filePath, []stateEntry{
+		{Kind: KIND_URL, URL: "https://example.com/1", StatusCode: 200, CheckedAt: now.Add(-time.Hour), Params: checkParams(KIND_URL)},
+		{Kind: KIND_URL, URL: "https://example.com/2", StatusCode: 404, Failed: true, CheckedAt: now.Add(-time.Hour), Params: checkParams(KIND_URL)},
+		{Kind: KIND_URL, URL: "https://example.com/3", StatusCode: 200, CheckedAt: now.Add(-48 * time.Hour), Params: checkParams(KIND_URL)},
+		// a later failure of a URL replaces its earlier success
+		{Kind: KIND_COMPANY, URL: "https://example.com/branch", StatusCode: 200, CheckedAt: now.Add(-2 * time.Hour)},
+		{Kind: KIND_COMPANY, URL: "https://example.com/branch", StatusCode: 500, Failed: true, CheckedAt: now.Add(-time.Hour)},
+	}, `{"kind":"url","url":"https://example.com/4","sta`)
+
+	s := newState(filePath, 24*time.Hour, true)
+	defer s.Close()
+
+	if _, ok := s.fresh(KIND_URL, "https://example.com/1"); !ok {
+		t.Error("URL verified an hour ago is not fresh")
+	}
+	if _, ok := s.fresh(KIND_URL, "https://example.com/2"); ok {
+		t.Error("failed URL is fresh")
+	}
+	if _, ok := s.fresh(KIND_URL, "https://example.com/3"); ok {
+		t.Error("URL verified two days ago is fresh")
+	}
+	if _, ok := s.fresh(KIND_COMPANY, "https://example.com/branch"); ok {
+		t.Error("URL failed after its success is fresh")
+	}
+	if _, ok := s.fresh(KIND_URL, "https://example.com/4"); ok {
+		t.Error("cut off line is loaded")
+	}
+	if _, ok := s.fresh(KIND_THUMBNAIL, "https://example.com/1"); ok {
+		t.Error("URL of another kind is fresh")
+	}
+
+	// the state file is compacted to the fresh URLs
+	entries := readState(t, filePath)
+	if len(entries) != 1 || entries[0].URL != "https://example.com/1" {
+		t.Errorf("compacted state %+v, want https://example.com/1 only", entries)
+	}
+}
+
+func TestStateStartOver(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "state.jsonl")
+	writeState(t, filePath, []stateEntry{
+		{Kind: KIND_URL, URL: "https://example.com/1", StatusCode: 200, CheckedAt: time.Now(), Params: checkParams(KIND_URL)},
+	}, "")
+
+	s := newState(filePath, 24*time.Hour, false)
+	defer s.Close()
+
+	if _, ok := s.fresh(KIND_URL, "https://example.com/1"); ok {
+		t.Error("URL of the previous check is fresh without resume")
+	}
+	if entries := readState(t, filePath); len(entries) != 0 {
+		t.Errorf("state %+v, want an empty state file", entries)
+	}
+}
+
+func TestStateRecord(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "state.jsonl")
+
+	s := newState(filePath, 24*time.Hour, false)
+	s.record(Result{Kind: KIND_URL, URL: "https://example.com/1", StatusCode: 200})
+	s.record(Result{Kind: KIND_URL, URL: "https://example.com/2", StatusCode: 404})
+	s.Close()
+
+	entries := readState(t, filePath)
+	if len(entries) != 2 {
+		t.Fatalf("state has %d entries, want 2", len(entries))
+	}
+	if entries[0].Failed || !entries[1].Failed {
+		t.Errorf("state %+v, want https://example.com/2 failed", entries)
+	}
+
+	resumed := newState(filePath, 24*time.Hour, true)
+	defer resumed.Close()
+
+	if entry, ok := resumed.fresh(KIND_URL, "https://example.com/1"); !ok || entry.StatusCode != 200 {
+		t.Errorf("recorded URL is not fresh, got %+v", entry)
+	}
+	if _, ok := resumed.fresh(KIND_URL, "https://example.com/2"); ok {
+		t.Error("recorded failure is fresh")
+	}
+}
+
+func TestStateFreshnessExpiry(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "state.jsonl")
+
+	s := newState(filePath, 50*time.Millisecond, false)
+	defer s.Close()
+
+	s.record(Result{Kind: KIND_URL, URL: "https://example.com/1", StatusCode: 200})
+	if _, ok := s.fresh(KIND_URL, "https://example.com/1"); !ok {
+		t.Fatal("URL just verified is not fresh")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if _, ok := s.fresh(KIND_URL, "https://example.com/1"); ok {
+		t.Error("URL is fresh after the freshness window")
+	}
+}
+
+func TestStateParams(t *testing.T) {
+	defer func(width int, height int) {
+		minWidth, minHeight = width, height
+	}(minWidth, minHeight)
+
+	filePath := filepath.Join(t.TempDir(), "state.jsonl")
+	s := newState(filePath, 24*time.Hour, false)
+	defer s.Close()
+
+	minWidth, minHeight = 320, 240
+	s.record(Result{Kind: KIND_IMAGE, URL: "https://example.com/1.jpg", StatusCode: 200, ContentType: "image/jpeg"})
+	if _, ok := s.fresh(KIND_IMAGE, "https://example.com/1.jpg"); !ok {
+		t.Fatal("image verified with the same minimum dimensions is not fresh")
+	}
+
+	minWidth, minHeight = 640, 480
+	if _, ok := s.fresh(KIND_IMAGE, "https://example.com/1.jpg"); ok {
+		t.Error("image verified with smaller minimum dimensions is fresh")
+	}
+
+	os.Setenv("URL_CHECK_CANONICAL", "true")
+	defer os.Unsetenv("URL_CHECK_CANONICAL")
+
+	s.record(Result{Kind: KIND_URL, URL: "https://example.com/1", StatusCode: 200})
+	if _, ok := s.fresh(KIND_URL, "https://example.com/1"); !ok {
+		t.Fatal("URL verified with the same settings is not fresh")
+	}
+
+	os.Unsetenv("URL_CHECK_CANONICAL")
+	if _, ok := s.fresh(KIND_URL, "https://example.com/1"); ok {
+		t.Error("URL verified with other page checks is fresh")
+	}
+}
@@ -1,10 +1,9 @@
 package urlchecker
 
 import (
-	"encoding/xml"
-	"fmt"
+	"errors"
+	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"os"
 	"strconv"
@@ -12,145 +11,287 @@ import (
 	"sync"
 	"time"
 
+	"bitbucket.org/waseka/waseka-xml-generator/feed"
+	"bitbucket.org/waseka/waseka-xml-generator/logger"
+	"bitbucket.org/waseka/waseka-xml-generator/metrics"
 	"bitbucket.org/waseka/waseka-xml-generator/utils"
 )
 
 var wg sync.WaitGroup
-var mut sync.Mutex
 
-type Rubrikk struct {
-	XMLName xml.Name        `xml:"rubrikk"`
-	Advert  []RubrikkAdvert `xml:"ad"`
+var checksTotal = metrics.NewCounter("xml_generator_url_checks_total", "Checked advert URLs and assets by kind and status code, error when the request failed", "kind", "status_code")
+var checkDuration = metrics.NewHistogram("xml_generator_url_check_duration_seconds", "Duration of the advert URL checks", metrics.DefBuckets)
+
+// check is a single URL of an advert of a feed, the advert URL or one of its assets
+type check struct {
+	feedLog       *logger.Logger
+	category      string
+	feed          string
+	advertId      int
+	line          int
+	kind          string
+	url           string
+	requestNumber int
 }
 
-var CategoryMap = map[string]string{
-	"feed1.xml": "residential-for-sale",
-	"feed2.xml": "residential-to-rent",
-	"feed3.xml": "commercial-for-sale",
-	"feed4.xml": "commercial-to-rent",
+func envNumber(key string, fallback float64) float64 {
+	number, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil || number < 0 {
+		return fallback
+	}
+	return number
 }
 
-type RubrikkAdvert struct {
-	XMLName              xml.Name `xml:"ad"`
-	AdHeadline           string   `xml:"ad__headline"`
-	Description          string   `xml:"ad__description"`
-	Price                float32  `xml:"ad__price"`
-	PriceCurrency        string   `xml:"ad__price_currency"`
-	CompanyURL           string   `xml:"advertiser__company_homepage_url"`
-	Mobile               string   `xml:"advertiser__mobile"`
-	Phone                string   `xml:"advertiser__phone"`
-	URL                  string   `xml:"ad__url"`
-	Thumbnail            string   `xml:"ad__imageurl"`
-	AdvertImages         []string `xml:"ad__all_imageurls>image"`
-	MainCategoryOriginal string   `xml:"maincategory_original"`
-	CategoryOriginal     string   `xml:"category_original"`
-	MunicipalityCity     string   `xml:"location__municipality_city"`
-	PostalName           string   `xml:"location__postal_name"`
-	Postcode             string   `xml:"location__zip_postal_code"`
-	Lat                  float32  `xml:"location__latitude"`
-	Lng                  float32  `xml:"location__longitude"`
-	StreetAddress        string   `xml:"location__streetaddress"`
+// advertChecks returns the checks of the advert URL and, unless "URL_CHECK_ASSETS" of .env
+// file is false, of the thumbnail, every image and the company URL of the advert
+func advertChecks(c check, advert feed.Advert) []check {
+	c.advertId = advert.Id
+	c.kind = KIND_URL
+	c.url = advert.URL
+	checks := []check{c}
+
+	if os.Getenv("URL_CHECK_ASSETS") == "false" {
+		return checks
+	}
+
+	assets := map[string][]string{
+		KIND_THUMBNAIL: {advert.Thumbnail},
+		KIND_IMAGE:     advert.AdvertImages,
+		KIND_COMPANY:   {advert.CompanyURL},
+	}
+	for _, kind := range []string{KIND_THUMBNAIL, KIND_IMAGE, KIND_COMPANY} {
+		for _, assetURL := range assets[kind] {
+			if assetURL == "" {
+				continue
+			}
+			c.kind = kind
+			c.url = assetURL
+			checks = append(checks, c)
+		}
+	}
+
+	return checks
 }
 
-func loadFeeds() []string {
-	if _, err := os.Stat("feeds"); err != nil {
-		err = os.MkdirAll("feeds", 0777)
+// CheckURL checks the advert URLs of every feed of the source, see Files of feed, with
+// "URL_CHECK_WORKERS" of .env file (default 10) workers. "URL_CHECK_HOST_RPS" (default 10) limits the requests per second
+// to a single host, e.g. APP_URL, and "URL_CHECK_RPS" (default 50) limits the requests
+// per second of all workers, 0 is unlimited. A resumed check skips the URLs of the state
+// file verified successfully within the freshness window, see openState
+func CheckURL(source string, resume bool) []Result {
+	workers := int(envNumber("URL_CHECK_WORKERS", 10))
+	if workers < 1 {
+		workers = 1
+	}
+	globalLimiter = newLimiter(envNumber("URL_CHECK_RPS", 50))
+	hostLimiters = newHostLimiter(envNumber("URL_CHECK_HOST_RPS", 10))
+	client = newClient(workers)
+	retries = int(envNumber("URL_CHECK_RETRIES", 3))
+	backoff = utils.EnvDuration("URL_CHECK_BACKOFF", time.Second)
+	minWidth = int(envNumber("URL_CHECK_IMAGE_MIN_WIDTH", 0))
+	minHeight = int(envNumber("URL_CHECK_IMAGE_MIN_HEIGHT", 0))
+	checked = map[string]*cachedCheck{}
+	progress = openState(resume)
+	defer progress.Close()
 
-		if err != nil {
+	// the feeds directory of the golang app is checked when the source is empty
+	if source == "" {
+		source = "feeds"
+		if err := os.MkdirAll(source, 0777); err != nil {
 			panic(err.Error())
 		}
 	}
 
-	files, err := ioutil.ReadDir("feeds")
+	feeds, err := feed.Files(source)
 	if err != nil {
 		panic(err.Error())
 	}
-	var feedList []string
-	for _, file := range files {
-		feedList = append(feedList, file.Name())
+	if len(feeds) <= 0 {
+		logger.Fatal("No feed available to test", "source", source)
 	}
 
-	return feedList
-}
+	var results []Result
+	var mut sync.Mutex
 
-func CheckURL() {
-	// make empty url-error-log.txt before testing
-	utils.EmptyFile("url-error-log.txt")
-	fileList := loadFeeds()
+	checks := make(chan check)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range checks {
+				result := checkOnce(c)
 
-	if len(fileList) <= 0 {
-		log.Fatal("No file available to test on feeds directory")
+				mut.Lock()
+				results = append(results, result)
+				mut.Unlock()
+			}
+		}()
 	}
 
-	for _, file := range fileList {
-		writeLogTitle(file)
-		xmlFile, err := os.Open("feeds/" + file)
-
+	for _, f := range feeds {
+		feedLog := logger.With("category", f.Category, "feed", f.Name)
+		body, err := feed.Open(f.Location)
 		if err != nil {
-			panic(err.Error())
+			feedLog.Error("Unable to open feed", "location", f.Location, "error", err)
+
+			mut.Lock()
+			results = append(results, Result{Category: f.Category, Feed: f.Name, Kind: KIND_FEED, URL: f.Location, Error: err.Error()})
+			mut.Unlock()
+			continue
 		}
 
-		fmt.Println("Successfully Opened " + file)
+		feedLog.Info("Feed opened", "location", f.Location)
 
-		defer xmlFile.Close()
+		reader := feed.NewReader(f.Name, body)
+		for i := 0; ; i++ {
+			advert, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
 
-		byteValue, _ := ioutil.ReadAll(xmlFile)
+			var feedErr *feed.Error
+			if errors.As(err, &feedErr) {
+				feedLog.Error("Invalid feed", "line", feedErr.Position.Line, "column", feedErr.Position.Column, "offset", feedErr.Position.Offset, "error", feedErr.Err)
 
-		var rubrikk Rubrikk
+				mut.Lock()
+				results = append(results, Result{Category: f.Category, Feed: f.Name, Kind: KIND_FEED, URL: f.Location, Line: feedErr.Position.Line, Error: feedErr.Error()})
+				mut.Unlock()
 
-		xml.Unmarshal(byteValue, &rubrikk)
+				if feedErr.Malformed {
+					break
+				}
+				continue
+			}
 
-		for i := 0; i < len(rubrikk.Advert); i++ {
-			// wg.Add(1)
-			sendRequest(rubrikk.Advert[i].URL, i)
+			advertCheck := check{feedLog: feedLog, category: f.Category, feed: f.Name, line: reader.Position().Line, requestNumber: i}
+			for _, c := range advertChecks(advertCheck, advert) {
+				checks <- c
+			}
 		}
-		// wg.Wait()
+		body.Close()
 	}
-}
 
-func sendRequest(url string, requestNumber int) {
-	// defer wg.Done()
-	http.DefaultClient.Timeout = time.Minute * 10
-	res, err := http.Get(url)
-	if err != nil {
-		panic(err)
-	}
+	close(checks)
+	wg.Wait()
+
+	reportBrokenAdverts(results)
+	writeReports(results)
 
-	if res.StatusCode != 200 {
-		createLog(url, res.StatusCode)
+	failed := 0
+	skipped := 0
+	for _, result := range results {
+		if result.Failed() {
+			failed++
+		}
+		if result.Skipped {
+			skipped++
+		}
 	}
+	logger.Info("URL check finished", "checked", len(results), "failed", failed, "skipped", skipped)
 
-	fmt.Printf("[%d] [%d] %s\n", requestNumber, res.StatusCode, url)
+	return results
 }
 
-func writeLogTitle(title string) {
-	f, err := os.OpenFile("url-error-log.txt", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+// sendRequest checks the URL, timeouts and server errors are retried "URL_CHECK_RETRIES" of
+// .env file times (default 3) after "URL_CHECK_BACKOFF" (default 1s), doubled on every retry.
+// Assets are requested with HEAD and with GET when the server does not support HEAD, the
+// images must be served as images. A request which still fails is recorded as a failed result
+func sendRequest(c check) Result {
+	requestStart := time.Now()
 
-	if err != nil {
-		panic(err.Error())
+	method := http.MethodGet
+	if c.kind != KIND_URL && !(isImage(c.kind) && hasMinDimensions()) {
+		method = http.MethodHead
 	}
 
-	output := []byte("\n---------- " + strings.ToUpper(CategoryMap[title]) + " ----------")
-	_, err = f.Write([]byte(append(output, "\n\n"...)))
-	if err != nil {
-		panic(err.Error())
+	result := fetch(c, method)
+	if method == http.MethodHead && (result.StatusCode == http.StatusMethodNotAllowed || result.StatusCode == http.StatusNotImplemented) {
+		attempts := result.Attempts
+		result = fetch(c, http.MethodGet)
+		result.Attempts += attempts
 	}
 
-	f.Close()
-}
+	result.Duration = time.Since(requestStart)
+	checkDuration.Observe(result.Duration.Seconds())
 
-func createLog(url string, statusCode int) {
-	f, err := os.OpenFile("url-error-log.txt", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if result.Error != "" {
+		checksTotal.Inc(c.kind, "error")
+	} else {
+		checksTotal.Inc(c.kind, strconv.Itoa(result.StatusCode))
+	}
 
-	if err != nil {
-		panic(err.Error())
+	if !result.Failed() && isImage(c.kind) {
+		result.Error = validateImage(result)
 	}
-	now := time.Now().Local().Format("2006-01-02 15:04:05")
-	output := []byte("[" + now + "] [" + strconv.Itoa(statusCode) + "] - " + url)
-	_, err = f.Write([]byte(append(output, "\n"...)))
-	if err != nil {
-		panic(err.Error())
+	if c.kind == KIND_URL {
+		result.Flags = append(redirectFlags(result), result.Flags...)
 	}
 
-	f.Close()
+	if result.Error != "" {
+		c.feedLog.Warn("URL failed", "request", c.requestNumber, "advert_id", c.advertId, "kind", c.kind, "attempts", result.Attempts, "status_code", result.StatusCode, "error", result.Error, "url", c.url)
+		return result
+	}
+
+	if result.Failed() {
+		c.feedLog.Warn("URL failed", "request", c.requestNumber, "advert_id", c.advertId, "kind", c.kind, "attempts", result.Attempts, "status_code", result.StatusCode, "flags", strings.Join(result.Flags, ","), "final_url", result.FinalURL, "url", c.url)
+		return result
+	}
+
+	c.feedLog.Info("URL checked", "request", c.requestNumber, "advert_id", c.advertId, "kind", c.kind, "status_code", result.StatusCode, "url", c.url)
+	return result
+}
+
+// fetch requests the URL with retries, the response of the last attempt is kept
+func fetch(c check, method string) Result {
+	result := Result{Category: c.category, Feed: c.feed, AdvertId: c.advertId, Line: c.line, Kind: c.kind, URL: c.url}
+	delay := backoff
+
+	for attempt := 1; ; attempt++ {
+		hostLimiters.Wait(c.url)
+		globalLimiter.Wait()
+
+		req, err := http.NewRequest(method, c.url, nil)
+		if err != nil {
+			result.Attempts = attempt
+			result.Error = err.Error()
+			return result
+		}
+
+		res, err := client.Do(req)
+		result.Attempts = attempt
+		result.StatusCode = 0
+		result.ContentType = ""
+		result.Redirects = nil
+		result.FinalURL = ""
+		result.Flags = nil
+		result.Error = ""
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.StatusCode = res.StatusCode
+			result.ContentType = res.Header.Get("Content-Type")
+			result.Redirects = redirectChain(res)
+			if len(result.Redirects) > 0 {
+				result.FinalURL = res.Request.URL.String()
+			}
+			if method == http.MethodGet && isImage(c.kind) && hasMinDimensions() && res.StatusCode == http.StatusOK {
+				result.Width, result.Height = imageDimensions(res.Body)
+			}
+			if method == http.MethodGet && c.kind == KIND_URL && res.StatusCode == http.StatusOK && isPageInspected() {
+				result.Flags = pageFlags(c.url, c.advertId, res.Request.URL, res.Body)
+			}
+
+			// the rest of the body is read so the connection is reused
+			io.Copy(ioutil.Discard, io.LimitReader(res.Body, 64*1024))
+			res.Body.Close()
+		}
+
+		if attempt > retries || !isRetryable(res, err) {
+			return result
+		}
+
+		c.feedLog.Debug("URL retried", "request", c.requestNumber, "kind", c.kind, "attempt", attempt, "status_code", result.StatusCode, "error", result.Error, "url", c.url)
+		time.Sleep(delay)
+		delay *= 2
+	}
 }
@@ -0,0 +1,131 @@
+package utils
+
+import (
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var CompressionExtensionMap = map[string]string{
+	"gzip": ".gz",
+	"zstd": ".zst",
+}
+
+// CompressionFormats reads "FEED_COMPRESSION" from .env file as a comma separated list,
+// e.g. "gzip,zstd". It defaults to gzip only and "none" disables compression, an unknown
+// format, e.g. a typo like "gz", fails instead of turning compression off
+func CompressionFormats() []string {
+	value := os.Getenv("FEED_COMPRESSION")
+	if value == "" {
+		return []string{"gzip"}
+	}
+
+	var formats []string
+	for _, format := range strings.Split(value, ",") {
+		format = strings.ToLower(strings.TrimSpace(format))
+		if format == "none" {
+			continue
+		}
+		if _, ok := CompressionExtensionMap[format]; !ok {
+			panic(fmt.Sprintf("Invalid FEED_COMPRESSION in .env file - unknown format %q, expected gzip, zstd or none", format))
+		}
+		formats = append(formats, format)
+	}
+
+	return formats
+}
+
+// CompressFeeds writes a compressed copy of every xml feed of the directory next to it,
+// e.g. feed1.xml.gz and feed1.xml.zst for feed1.xml
+func CompressFeeds(dirName string) {
+	files, err := ioutil.ReadDir(dirName)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	formats := CompressionFormats()
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".xml" {
+			continue
+		}
+
+		for _, format := range formats {
+			compressFile(filepath.Join(dirName, file.Name()), format)
+		}
+	}
+}
+
+func compressFile(filePath string, format string) {
+	compressedPath := filePath + CompressionExtensionMap[format]
+
+	// feeds of the categories which are not regenerated keep their compressed variant
+	src, err := os.Open(filePath)
+	if err != nil {
+		panic(err.Error())
+	}
+	defer src.Close()
+
+	srcInfo, err := src.Stat()
+	if err != nil {
+		panic(err.Error())
+	}
+	if info, err := os.Stat(compressedPath); err == nil && !info.ModTime().Before(srcInfo.ModTime()) {
+		return
+	}
+
+	// the compressed variant may be hard linked into an exported release, so it is
+	// written to a new file which replaces it rather than truncated in place
+	dst, err := os.OpenFile(compressedPath+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0777)
+	if err != nil {
+		panic(err.Error())
+	}
+	defer dst.Close()
+
+	var writer io.WriteCloser
+	if format == "zstd" {
+		writer, err = zstd.NewWriter(dst, zstd.WithEncoderLevel(zstd.SpeedBetterCompression))
+	} else {
+		writer, err = gzip.NewWriterLevel(dst, gzip.BestCompression)
+	}
+	if err != nil {
+		panic(err.Error())
+	}
+
+	if _, err = io.Copy(writer, src); err != nil {
+		panic(err.Error())
+	}
+
+	if err = writer.Close(); err != nil {
+		panic(err.Error())
+	}
+
+	if err = os.Rename(compressedPath+".tmp", compressedPath); err != nil {
+		panic(err.Error())
+	}
+}
+
+// WriteXMLFile writes v with the XML header to a new file which replaces filePath, like the
+// compressed variants the sitemaps and syndication feeds may be hard linked into an exported
+// release
+func WriteXMLFile(filePath string, v interface{}) {
+	output, err := xml.MarshalIndent(v, "", "    ")
+	if err != nil {
+		panic(err.Error())
+	}
+	output = append([]byte(xml.Header), output...)
+
+	if err = os.WriteFile(filePath+".tmp", append(output, "\n"...), 0777); err != nil {
+		panic(err.Error())
+	}
+
+	if err = os.Rename(filePath+".tmp", filePath); err != nil {
+		panic(err.Error())
+	}
+}
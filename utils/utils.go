@@ -1,15 +1,21 @@
 package utils
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/xml"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
+
+	"bitbucket.org/waseka/waseka-xml-generator/logger"
 
 	"github.com/shopspring/decimal"
 )
@@ -28,6 +34,20 @@ var FileNameMap = map[string]string{
 	"commercial-to-rent":   "feed4.xml",
 }
 
+// CategoryByFileName resolves the property category of a feed file, including the
+// split parts and compressed variants, e.g. feed1-002.xml.gz is residential-for-sale
+func CategoryByFileName(fileName string) string {
+	baseName := strings.SplitN(filepath.Base(fileName), ".", 2)[0]
+	baseName = strings.SplitN(baseName, "-", 2)[0]
+
+	for category, feedName := range FileNameMap {
+		if feedName == baseName+".xml" {
+			return category
+		}
+	}
+	return ""
+}
+
 type Property struct {
 	Id               int
 	AgentBranchId    int
@@ -58,6 +78,16 @@ type Property struct {
 	Mobile           sql.NullString
 }
 
+// EnvDuration parses a duration of .env file, e.g. URL_CHECK_TIMEOUT=30s. A missing,
+// invalid, zero or negative value returns the fallback
+func EnvDuration(key string, fallback time.Duration) time.Duration {
+	duration, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || duration <= 0 {
+		return fallback
+	}
+	return duration
+}
+
 func VerifyInput(propertyCategory string) (string, error) {
 	availableInput := []string{
 		"residential-for-sale",
@@ -79,6 +109,12 @@ func VerifyExecutionType(executionType string) (string, error) {
 	availableInput := []string{
 		"parse",
 		"test",
+		"rollback",
+		"verify",
+		"serve",
+		"daemon",
+		"status",
+		"diff",
 	}
 
 	for i := 0; i < len(availableInput); i++ {
@@ -134,7 +170,7 @@ func CompanyURL(branchName string, branchId int) string {
 	re, err := regexp.Compile("[^a-zA-Z0-9]+")
 
 	if err != nil {
-		log.Fatal(err)
+		logger.Fatal("Invalid company URL pattern", "error", err)
 	}
 
 	branchName = strings.ToLower(branchName)
@@ -149,11 +185,27 @@ func RemoveExistentContents(dirName string) {
 	os.MkdirAll(dirName, 0777)
 }
 
+// RemoveCategoryFeeds removes the feeds of a category, including its split parts and
+// compressed variants, and keeps the feeds of the other categories
+func RemoveCategoryFeeds(dirName string, propertyCategory string) {
+	files, err := ioutil.ReadDir(dirName)
+	if err != nil {
+		os.MkdirAll(dirName, 0777)
+		return
+	}
+
+	for _, file := range files {
+		if CategoryByFileName(file.Name()) == propertyCategory {
+			os.Remove(dirName + "/" + file.Name())
+		}
+	}
+}
+
 func EmptyFile(filePath string) {
 	if _, err := os.Stat(filePath); err != nil {
 		err := os.WriteFile(filePath, []byte(""), 0755)
 		if err != nil {
-			fmt.Printf("Unable to write file: %v", err)
+			logger.Error("Unable to write file", "file", filePath, "error", err)
 		}
 	}
 
@@ -162,34 +214,30 @@ func EmptyFile(filePath string) {
 	}
 }
 
-func TransferFeeds() {
-	createPublicXmlFile()
-
-	exportPath := os.Getenv("EXPORT_PATH") + "/feeds"
-	os.RemoveAll(exportPath)
-	err := os.Rename("feeds", exportPath)
-
-	if err != nil {
-		panic(err.Error())
-	}
-
-	err = os.Chmod(exportPath, 0777)
-
-	if err != nil {
-		panic(err.Error())
-	}
-
-	err = os.MkdirAll("feeds", 0777)
+// ExportDirectories are published by every export target next to feed.xml
+var ExportDirectories = []string{
+	"feeds",
+	"sitemaps",
+	"syndication",
+}
 
-	if err != nil {
-		panic(err.Error())
+// CreatePublicXmlFile writes the feed.xml index of the feeds directory, every feed is
+// listed under baseURL, e.g. "APP_URL/feeds/"
+func CreatePublicXmlFile(baseURL string) {
+	// Every <loc> keeps the feed URL as its content, the details of the feed are
+	// carried as attributes so that existing consumers of feed.xml keep working
+	type FeedLocation struct {
+		URL      string `xml:",chardata"`
+		Category string `xml:"category,attr,omitempty"`
+		Adverts  int    `xml:"adverts,attr"`
+		Size     int64  `xml:"size,attr"`
+		SHA256   string `xml:"sha256,attr"`
+		LastMod  string `xml:"lastmod,attr"`
 	}
-}
 
-func createPublicXmlFile() {
 	type FeedXml struct {
-		XMLName  xml.Name `xml:"links"`
-		Location []string `xml:"loc"`
+		XMLName  xml.Name       `xml:"links"`
+		Location []FeedLocation `xml:"loc"`
 	}
 
 	// Reading all parsed xml file from feeds directory to generate feed.xml file
@@ -207,9 +255,24 @@ func createPublicXmlFile() {
 		}
 	}
 
+	// Compressed variants are listed after their xml feed and share its advert count
+	advertCounts := make(map[string]int)
 	var feedXml FeedXml
 	for _, file := range files {
-		feedXml.Location = append(feedXml.Location, os.Getenv("APP_URL")+"/feeds/"+file.Name())
+		filePath := "feeds/" + file.Name()
+		baseName := strings.SplitN(file.Name(), ".xml", 2)[0] + ".xml"
+		if baseName == file.Name() {
+			advertCounts[baseName] = countAdverts(filePath)
+		}
+
+		feedXml.Location = append(feedXml.Location, FeedLocation{
+			URL:      baseURL + file.Name(),
+			Category: CategoryByFileName(file.Name()),
+			Adverts:  advertCounts[baseName],
+			Size:     file.Size(),
+			SHA256:   FileChecksum(filePath),
+			LastMod:  file.ModTime().Format(time.RFC3339),
+		})
 	}
 
 	// Creating feed.xml file
@@ -227,21 +290,49 @@ func createPublicXmlFile() {
 		}
 
 		f.Close()
+	}
+}
 
-		// Moving feed.xml file to export path
-		err = os.Rename("feed.xml", os.Getenv("EXPORT_PATH")+"/feed.xml")
-
+func countAdverts(filePath string) int {
+	f, err := os.Open(filePath)
+	if err != nil {
+		panic(err.Error())
+	}
+	defer f.Close()
+
+	var count int
+	decoder := xml.NewDecoder(f)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
 			panic(err.Error())
 		}
 
-		// Giving feed.xml file permission
-		err = os.Chmod(os.Getenv("EXPORT_PATH")+"/feed.xml", 0777)
-
-		if err != nil {
-			panic(err.Error())
+		if element, ok := token.(xml.StartElement); ok && element.Name.Local == "ad" {
+			count++
 		}
 	}
+
+	return count
+}
+
+// FileChecksum returns the hex encoded SHA-256 of the file
+func FileChecksum(filePath string) string {
+	f, err := os.Open(filePath)
+	if err != nil {
+		panic(err.Error())
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		panic(err.Error())
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
 }
 
 func PriceInDecimal(price float64) decimal.Decimal {